@@ -0,0 +1,250 @@
+package accter
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultClientTimeout is the time to wait for a response before the request is sent again
+	DefaultClientTimeout = 3 * time.Second
+	// DefaultClientRetries is the number of retransmissions to a server before the next one is tried
+	DefaultClientRetries = 2
+)
+
+// AccountingServer is a server the AccountingClient sends requests to
+type AccountingServer struct {
+	// Addr is the host and port like "radius.example.com:1813"
+	Addr   string
+	Secret string
+}
+
+/*
+ * AccountingClient sends Accounting-Requests over UDP. The servers are tried
+ * in order, a server is given up once it did not respond to the request and
+ * all retransmissions. Retransmissions are sent unchanged as described in
+ * RFC 5080 section 2.2.1, the next server gets a new packet with its own
+ * identifier and an updated Acct-Delay-Time.
+ */
+type AccountingClient struct {
+	Servers []AccountingServer
+	// Timeout per transmission, defaults to DefaultClientTimeout
+	Timeout time.Duration
+	// Retries is the number of retransmissions per server, defaults to
+	// DefaultClientRetries, a negative number disables retransmissions
+	Retries int
+	mu      sync.Mutex
+	ids     map[string]*identifiers
+}
+
+// identifiers allocates the identifiers of the requests in flight to a server
+type identifiers struct {
+	mu    sync.Mutex
+	next  uint8
+	inUse [256]bool
+}
+
+func (i *identifiers) allocate() (uint8, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for n := 0; n < len(i.inUse); n++ {
+		id := i.next
+		i.next++
+		if !i.inUse[id] {
+			i.inUse[id] = true
+			return id, nil
+		}
+	}
+	return 0, errors.New("all identifiers in use")
+}
+
+func (i *identifiers) release(id uint8) {
+	i.mu.Lock()
+	i.inUse[id] = false
+	i.mu.Unlock()
+}
+
+/*
+ * Send sends an Accounting-Request with the given attributes and returns the
+ * Accounting-Response. Responses with a wrong identifier or an invalid
+ * Response Authenticator are ignored. Acct-Delay-Time is increased by the
+ * seconds Send is waiting for a response, it is added if it is missing and
+ * the request is delayed.
+ */
+func (c *AccountingClient) Send(ctx context.Context, attrs []RadiusAttribute) (*RequestPacket, error) {
+	return c.send(ctx, c.Servers, attrs, nil)
+}
+
+// send tries the servers in order, report is called with the result of every server tried
+func (c *AccountingClient) send(ctx context.Context, servers []AccountingServer, attrs []RadiusAttribute, report func(AccountingServer, error)) (*RequestPacket, error) {
+	if len(servers) == 0 {
+		return nil, errors.New("client has no servers")
+	}
+	start := time.Now()
+	delay, err := acctDelayTime(attrs)
+	if err != nil {
+		return nil, err
+	}
+	var errs []string
+	for _, server := range servers {
+		elapsed := uint32(time.Since(start) / time.Second)
+		response, err := c.sendTo(ctx, server, withAcctDelayTime(attrs, delay+elapsed))
+		if err != nil && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			return nil, err
+		}
+		if report != nil {
+			report(server, err)
+		}
+		if err == nil {
+			return response, nil
+		}
+		errs = append(errs, fmt.Sprintf("%s: %v", server.Addr, err))
+	}
+	return nil, fmt.Errorf("no accounting server responded: %v", errs)
+}
+
+func (c *AccountingClient) sendTo(ctx context.Context, server AccountingServer, attrs []RadiusAttribute) (*RequestPacket, error) {
+	ids := c.identifiers(server.Addr)
+	id, err := ids.allocate()
+	if err != nil {
+		return nil, err
+	}
+	defer ids.release(id)
+	request := &RequestPacket{
+		Code:       CodeAccountingRequest,
+		Identifier: id,
+		Secret:     []byte(server.Secret),
+		Attributes: attrs,
+	}
+	b, err := request.Encode()
+	if err != nil {
+		return nil, err
+	}
+	copy(request.Authenticator[:], b[4:20])
+	return c.exchange(ctx, server.Addr, b, request)
+}
+
+// exchange sends b until a valid response to request is received or all retries timed out
+func (c *AccountingClient) exchange(ctx context.Context, addr string, b []byte, request *RequestPacket) (*RequestPacket, error) {
+	conn, err := (&net.Dialer{}).DialContext(ctx, "udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetReadDeadline(time.Now())
+		case <-done:
+		}
+	}()
+	timeout, retries := c.Timeout, c.Retries
+	if timeout <= 0 {
+		timeout = DefaultClientTimeout
+	}
+	if retries == 0 {
+		retries = DefaultClientRetries
+	} else if retries < 0 {
+		retries = 0
+	}
+	buff := make([]byte, MaxPacketLength)
+	for attempt := 0; attempt <= retries; attempt++ {
+		if _, err := conn.Write(b); err != nil {
+			return nil, err
+		}
+		deadline := time.Now().Add(timeout)
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+		conn.SetReadDeadline(deadline)
+		for {
+			n, err := conn.Read(buff)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+				if d, ok := ctx.Deadline(); ok && !time.Now().Before(d) {
+					// the read deadline of the context can pass before ctx.Err is set
+					return nil, context.DeadlineExceeded
+				}
+				var netErr net.Error
+				if errors.As(err, &netErr) && netErr.Timeout() {
+					break
+				}
+				return nil, err
+			}
+			if response := checkResponse(buff[:n], request); response != nil {
+				return response, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no response after %d transmissions", retries+1)
+}
+
+// checkResponse returns the parsed response if it is a valid response to request
+func checkResponse(b []byte, request *RequestPacket) *RequestPacket {
+	response, err := ParsePacket(b, request.Secret)
+	if err != nil {
+		return nil
+	}
+	if response.Code != CodeAccountingResponse || response.Identifier != request.Identifier {
+		return nil
+	}
+	if !response.VerifyResponseAuthenticator(request.Authenticator) {
+		return nil
+	}
+	return response
+}
+
+func (c *AccountingClient) identifiers(addr string) *identifiers {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ids == nil {
+		c.ids = make(map[string]*identifiers)
+	}
+	if c.ids[addr] == nil {
+		c.ids[addr] = &identifiers{}
+	}
+	return c.ids[addr]
+}
+
+// acctDelayTime returns the Acct-Delay-Time of attrs, zero if there is none
+func acctDelayTime(attrs []RadiusAttribute) (uint32, error) {
+	for _, attr := range attrs {
+		if attr.Type == typeAcctDelayTime {
+			if len(attr.Value) != 4 {
+				return 0, errors.New("invalid Acct-Delay-Time")
+			}
+			return binary.BigEndian.Uint32(attr.Value), nil
+		}
+	}
+	return 0, nil
+}
+
+// withAcctDelayTime returns a copy of attrs with the Acct-Delay-Time set to delay
+func withAcctDelayTime(attrs []RadiusAttribute, delay uint32) []RadiusAttribute {
+	value := binary.BigEndian.AppendUint32(nil, delay)
+	updated := make([]RadiusAttribute, 0, len(attrs)+1)
+	found := false
+	for _, attr := range attrs {
+		if attr.Type == typeAcctDelayTime {
+			attr = RadiusAttribute{Type: typeAcctDelayTime, Length: 6, Value: value}
+			found = true
+		}
+		updated = append(updated, attr)
+	}
+	if !found && delay > 0 {
+		updated = append(updated, RadiusAttribute{Type: typeAcctDelayTime, Length: 6, Value: value})
+	}
+	return updated
+}
@@ -0,0 +1,176 @@
+package accter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// StartFakeServer answers requests with the response of reply, nil responses are
+// dropped. The returned function lists the received packets.
+func StartFakeServer(t *testing.T, reply func(n int, request *RequestPacket) []byte) (string, func() [][]byte) {
+	port, addr := NextTestAddr()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: port})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	var mu sync.Mutex
+	received := make([][]byte, 0)
+	go func() {
+		buff := make([]byte, MaxPacketLength)
+		for {
+			n, remote, err := conn.ReadFromUDP(buff)
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			received = append(received, append([]byte(nil), buff[:n]...))
+			count := len(received)
+			mu.Unlock()
+			request, err := ParsePacket(buff[:n], []byte("secret"))
+			if err != nil {
+				continue
+			}
+			if response := reply(count, request); response != nil {
+				conn.WriteToUDP(response, remote)
+			}
+		}
+	}()
+	return addr, func() [][]byte {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([][]byte(nil), received...)
+	}
+}
+
+func TestAccountingClient(t *testing.T) {
+	sessionIds := make(chan string, 1)
+	handler := func(packet *JsonPacket) error {
+		for _, attr := range packet.Attributes {
+			if attr.Name == "Acct-Session-Id" {
+				sessionIds <- attr.Value
+			}
+		}
+		return nil
+	}
+	addr := StartTestServer(t, NewTestServer(handler, true))
+	client := &AccountingClient{Servers: []AccountingServer{{Addr: addr, Secret: "secret"}}}
+	response, err := client.Send(context.Background(), NewTestPacket(0, "secret").Attributes)
+	if err != nil {
+		t.Fatalf("Send failed with: %v", err)
+	}
+	if response.Code != CodeAccountingResponse {
+		t.Errorf("response.Code = %q, want %q", response.Code, CodeAccountingResponse)
+	}
+	if got := <-sessionIds; got != testSessionId {
+		t.Errorf("Acct-Session-Id = %q, want %q", got, testSessionId)
+	}
+}
+
+func TestAccountingClientRetransmission(t *testing.T) {
+	addr, received := StartFakeServer(t, func(n int, request *RequestPacket) []byte {
+		if n == 1 {
+			return nil
+		}
+		response, _ := encodeResponse(CodeAccountingResponse, request, nil)
+		return response
+	})
+	client := &AccountingClient{
+		Servers: []AccountingServer{{Addr: addr, Secret: "secret"}},
+		Timeout: 200 * time.Millisecond,
+	}
+	if _, err := client.Send(context.Background(), NewTestPacket(0, "secret").Attributes); err != nil {
+		t.Fatalf("Send failed with: %v", err)
+	}
+	packets := received()
+	if len(packets) != 2 {
+		t.Fatalf("got %d transmissions, want 2", len(packets))
+	}
+	if !bytes.Equal(packets[0], packets[1]) {
+		t.Error("retransmission differs from the first transmission")
+	}
+}
+
+func TestAccountingClientFailover(t *testing.T) {
+	delays := make(chan string, 1)
+	handler := func(packet *JsonPacket) error {
+		for _, attr := range packet.Attributes {
+			if attr.Name == "Acct-Delay-Time" {
+				delays <- attr.Value
+			}
+		}
+		return nil
+	}
+	silent, _ := StartFakeServer(t, func(int, *RequestPacket) []byte { return nil })
+	addr := StartTestServer(t, NewTestServer(handler, true))
+	client := &AccountingClient{
+		Servers: []AccountingServer{{Addr: silent, Secret: "secret"}, {Addr: addr, Secret: "secret"}},
+		Timeout: 1100 * time.Millisecond,
+		Retries: -1,
+	}
+	delay, _ := NewAttribute("Acct-Delay-Time", 10)
+	if _, err := client.Send(context.Background(), NewTestPacket(0, "secret", delay).Attributes); err != nil {
+		t.Fatalf("Send failed with: %v", err)
+	}
+	if got := <-delays; got != "11" {
+		t.Errorf("Acct-Delay-Time = %s, want 11", got)
+	}
+}
+
+func TestAccountingClientInvalidResponse(t *testing.T) {
+	addr, received := StartFakeServer(t, func(n int, request *RequestPacket) []byte {
+		request.Secret = []byte("wrong-secret")
+		response, _ := encodeResponse(CodeAccountingResponse, request, nil)
+		return response
+	})
+	client := &AccountingClient{
+		Servers: []AccountingServer{{Addr: addr, Secret: "secret"}},
+		Timeout: 200 * time.Millisecond,
+		Retries: 1,
+	}
+	if _, err := client.Send(context.Background(), NewTestPacket(0, "secret").Attributes); err == nil {
+		t.Error("response with invalid authenticator accepted")
+	}
+	if got := len(received()); got != 2 {
+		t.Errorf("got %d transmissions, want 2", got)
+	}
+}
+
+func TestAccountingClientCancel(t *testing.T) {
+	addr, _ := StartFakeServer(t, func(int, *RequestPacket) []byte { return nil })
+	client := &AccountingClient{
+		Servers: []AccountingServer{{Addr: addr, Secret: "secret"}},
+		Timeout: 5 * time.Second,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := client.Send(ctx, NewTestPacket(0, "secret").Attributes)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("Send returned after %s", time.Since(start))
+	}
+}
+
+func TestIdentifiers(t *testing.T) {
+	ids := &identifiers{}
+	for i := 0; i < 256; i++ {
+		if id, err := ids.allocate(); err != nil || id != uint8(i) {
+			t.Fatalf("got %d, %v, want %d", id, err, i)
+		}
+	}
+	if _, err := ids.allocate(); err == nil {
+		t.Error("allocated more than 256 identifiers")
+	}
+	ids.release(42)
+	if id, err := ids.allocate(); err != nil || id != 42 {
+		t.Errorf("got %d, %v, want 42", id, err)
+	}
+}
@@ -6,44 +6,106 @@ import (
 	"context"
 	"crypto/md5"
 	"encoding/binary"
-	"encoding/hex"
+	"errors"
 	"fmt"
 	"net"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
 
 const (
-	testHexStr = "04%s005883aa46a746f4a9cc25e9037492695d7c0114746573747573657240646f6d61696e2e63682c" +
-		"07742d3830302806000000030506000000050406010203042a06000004d22b060000ddd5190654455354200570616e"
 	testAuthenticator = "83aa46a746f4a9cc25e9037492695d7c"
 	testSessionId     = "t-800"
 )
 
+// testAttributes are the attributes of the test packet in their order on the wire
+var testAttributes = []struct {
+	name  string
+	value interface{}
+}{
+	{"User-Name", "testuser@domain.ch"},
+	{"Acct-Session-Id", testSessionId},
+	{"Acct-Status-Type", "Interim-Update"},
+	{"NAS-Port", 5},
+	{"NAS-IP-Address", net.IPv4(1, 2, 3, 4)},
+	{"Acct-Input-Octets", 1234},
+	{"Acct-Output-Octets", 56789},
+	{"Class", "TEST"},
+	{"NAS-Identifier", "pan"},
+}
+
+var testPort atomic.Int32
+
+func init() {
+	testPort.Store(1812)
+}
+
+// NextTestAddr returns a fresh local address so every test gets its own server
+func NextTestAddr() (int, string) {
+	port := int(testPort.Add(1))
+	return port, fmt.Sprintf("localhost:%d", port)
+}
+
+// NewTestPacket returns the test packet with additional attributes
+func NewTestPacket(id byte, secret string, attrs ...RadiusAttribute) *RequestPacket {
+	packet := &RequestPacket{Code: CodeAccountingRequest, Identifier: id, Secret: []byte(secret)}
+	for _, a := range testAttributes {
+		attr, err := NewAttribute(a.name, a.value)
+		if err != nil {
+			panic(err)
+		}
+		packet.Attributes = append(packet.Attributes, attr)
+	}
+	packet.Attributes = append(packet.Attributes, attrs...)
+	return packet
+}
+
 func GetTestPacket(id byte) []byte {
-	decodedByteArray, _ := hex.DecodeString(fmt.Sprintf(testHexStr, hex.EncodeToString([]byte{id})))
-	return decodedByteArray
+	b, err := NewTestPacket(id, "secret").Encode()
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// SignTestPacket writes the request authenticator for the given secret into b
+func SignTestPacket(b []byte, secret string) {
+	hash := md5.New()
+	hash.Write(b[:4])
+	hash.Write(make([]byte, 16))
+	hash.Write(b[20:])
+	hash.Write([]byte(secret))
+	hash.Sum(b[4:4:20])
 }
 
 func ExchangePacket(ctx context.Context, b []byte, addr string) (Code, error) {
+	p, err := ExchangeRawPacket(ctx, b, addr)
+	if err != nil {
+		return 0, err
+	}
+	return Code(p[0]), nil
+}
+
+func ExchangeRawPacket(ctx context.Context, b []byte, addr string) ([]byte, error) {
 	d := &net.Dialer{}
 	conn, err := d.DialContext(ctx, "udp", addr)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 	defer conn.Close()
 	_, err = conn.Write(b)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 	done := make(chan struct{})
 	var packetError error
-	var code Code
-	go func(e error, c Code) {
+	var response []byte
+	go func() {
 		defer close(done)
 		p := make([]byte, 4096)
-		_, err = bufio.NewReader(conn).Read(p)
+		n, err := bufio.NewReader(conn).Read(p)
 		if err != nil {
 			packetError = err
 			return
@@ -52,53 +114,57 @@ func ExchangePacket(ctx context.Context, b []byte, addr string) (Code, error) {
 			packetError = err
 			return
 		}
-		code = Code(p[0])
-	}(packetError, code)
+		response = p[:n]
+	}()
 	select {
 	case <-done:
-		return code, packetError
+		return response, packetError
 	case <-ctx.Done():
-		return 0, ctx.Err()
+		return nil, ctx.Err()
 	}
 }
 
-/*
-Important: this is not a real authenticator check, this is bacause we assume that the
-length of the answer is always 20 bytes long. This is only true cos accter does not send
-any attributes in the response and therfore the length is always 20 bytes long.
-*/
+// CheckAuthenticator verifies the response p to the request with sendAuthenticator
 func CheckAuthenticator(sendAuthenticator, p []byte) error {
-	got := make([]byte, 16)
-	copy(got, p[4:20])
-	want := make([]byte, 20)
-	want[0] = p[0]
-	want[1] = p[1]
-	binary.BigEndian.PutUint16(want[2:4], 20)
-	hash := md5.New()
-	hash.Write(want[:4])
-	hash.Write(sendAuthenticator)
-	hash.Write([]byte("secret"))
-	hash.Sum(want[4:4:20])
-	if !bytes.Equal(got, want[4:20]) {
-		return fmt.Errorf("authenticator mismatch want: %x got: %x", want[4:20], got)
+	response, err := ParsePacket(p, []byte("secret"))
+	if err != nil {
+		return err
+	}
+	var authenticator [16]byte
+	copy(authenticator[:], sendAuthenticator)
+	if !response.VerifyResponseAuthenticator(authenticator) {
+		return fmt.Errorf("invalid response authenticator %x", response.Authenticator)
 	}
 	return nil
 }
 
-func StartTestServer(f func(packet *JsonPacket) error, r bool) {
-	server := &PacketServer{
-		Port:                1813,
+/*
+ * StartTestServer starts the server on the next test port and returns its
+ * address. The server is ready once it returned and shut down when the test
+ * finished. LogLevel defaults to Trace.
+ */
+func StartTestServer(t *testing.T, server *PacketServer) string {
+	t.Helper()
+	port, addr := NextTestAddr()
+	server.Port = port
+	if server.LogLevel == 0 {
+		server.LogLevel = Trace
+	}
+	if err := server.Listen(); err != nil {
+		t.Fatalf("error starting server: %v", err)
+	}
+	go server.Serve()
+	t.Cleanup(server.Shutdown)
+	return addr
+}
+
+// NewTestServer returns a server with the secret "secret" and the handler f
+func NewTestServer(f func(packet *JsonPacket) error, allowRetransmission bool) *PacketServer {
+	return &PacketServer{
 		Secret:              "secret",
 		HandleRequest:       f,
-		AllowRetransmission: r,
-		LogLevel:            Trace,
+		AllowRetransmission: allowRetransmission,
 	}
-	go func(server *PacketServer) {
-		if err := server.Serve(); err != nil {
-			panic("error starting server: " + err.Error())
-		}
-	}(server)
-	time.Sleep(100 * time.Millisecond)
 }
 
 func TestOneRequest(t *testing.T) {
@@ -115,10 +181,10 @@ func TestOneRequest(t *testing.T) {
 		}
 		return nil
 	}
-	StartTestServer(handler, false)
+	addr := StartTestServer(t, NewTestServer(handler, false))
 	id := byte(1)
 	testPacket := GetTestPacket(id)
-	response, err := ExchangePacket(context.Background(), testPacket, "localhost:1813")
+	response, err := ExchangePacket(context.Background(), testPacket, addr)
 	if err != nil {
 		t.Errorf(err.Error())
 	}
@@ -138,10 +204,13 @@ func TestOneRequest(t *testing.T) {
 }
 
 func TestRetransRequest(t *testing.T) {
+	var mu sync.Mutex
 	var gotAuthenticator string
 	var gotId string
 	var gotSessionId string
 	handler := func(packet *JsonPacket) error {
+		mu.Lock()
+		defer mu.Unlock()
 		gotAuthenticator = packet.Authenticator
 		gotId = packet.Id
 		for _, attr := range packet.Attributes {
@@ -151,10 +220,10 @@ func TestRetransRequest(t *testing.T) {
 		}
 		return nil
 	}
-	StartTestServer(handler, false)
+	addr := StartTestServer(t, NewTestServer(handler, false))
 	id := byte(1)
 	testPacket := GetTestPacket(id)
-	response, err := ExchangePacket(context.Background(), testPacket, "localhost:1813")
+	response, err := ExchangePacket(context.Background(), testPacket, addr)
 	if err != nil {
 		t.Errorf(err.Error())
 	}
@@ -162,6 +231,7 @@ func TestRetransRequest(t *testing.T) {
 	if response != wantResp {
 		t.Errorf("response.Code = %q, want %q", response, wantResp)
 	}
+	mu.Lock()
 	if gotAuthenticator != testAuthenticator {
 		t.Errorf("Authenticator = %q, want %q", gotAuthenticator, testAuthenticator)
 	}
@@ -171,20 +241,135 @@ func TestRetransRequest(t *testing.T) {
 	if gotSessionId != testSessionId {
 		t.Errorf("Acct-Session-Id = %q, want %q", gotSessionId, testSessionId)
 	}
+	mu.Unlock()
+	// a retransmission from another source port is a new request
 	c, cancel := context.WithTimeout(context.Background(), 1*time.Second)
-	e, err := ExchangePacket(c, testPacket, "localhost:1813")
-	fmt.Println(e)
-	if err.Error() != "context deadline exceeded" {
-		t.Errorf("timeout.res = %q, want (context deadline exceeded)", err.Error())
-	}
 	defer cancel()
+	if _, err := ExchangePacket(c, testPacket, addr); err != nil {
+		t.Errorf("request from another port failed with: %v", err)
+	}
+}
+
+// DialTestServer returns a connection which sends all packets from the same source port
+func DialTestServer(t *testing.T, addr string) net.Conn {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// ReadTestResponses returns the packets received on conn within timeout
+func ReadTestResponses(conn net.Conn, timeout time.Duration) [][]byte {
+	var responses [][]byte
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	for {
+		p := make([]byte, MaxPacketLength)
+		n, err := conn.Read(p)
+		if err != nil {
+			return responses
+		}
+		responses = append(responses, p[:n])
+	}
+}
+
+func TestReplayResponse(t *testing.T) {
+	var calls atomic.Int32
+	handler := func(packet *JsonPacket) error {
+		calls.Add(1)
+		return nil
+	}
+	addr := StartTestServer(t, NewTestServer(handler, false))
+	testPacket := GetTestPacket(1)
+	conn := DialTestServer(t, addr)
+	conn.Write(testPacket)
+	first := ReadTestResponses(conn, 500*time.Millisecond)
+	if len(first) != 1 {
+		t.Fatalf("got %d responses, want 1", len(first))
+	}
+	conn.Write(testPacket)
+	replayed := ReadTestResponses(conn, 500*time.Millisecond)
+	if len(replayed) != 1 {
+		t.Fatalf("got %d responses to the retransmission, want 1", len(replayed))
+	}
+	if !bytes.Equal(first[0], replayed[0]) {
+		t.Errorf("replayed response %x differs from %x", replayed[0], first[0])
+	}
+	if got := calls.Load(); got != 1 {
+		t.Errorf("handler called %d times, want 1", got)
+	}
+}
+
+func TestRetransmissionInFlight(t *testing.T) {
+	var calls atomic.Int32
+	handler := func(packet *JsonPacket) error {
+		calls.Add(1)
+		time.Sleep(300 * time.Millisecond)
+		return nil
+	}
+	addr := StartTestServer(t, NewTestServer(handler, false))
+	testPacket := GetTestPacket(1)
+	conn := DialTestServer(t, addr)
+	conn.Write(testPacket)
+	conn.Write(testPacket)
+	if got := len(ReadTestResponses(conn, time.Second)); got != 1 {
+		t.Errorf("got %d responses, want 1", got)
+	}
+	if got := calls.Load(); got != 1 {
+		t.Errorf("handler called %d times, want 1", got)
+	}
+}
+
+func TestRetransmissionAfterFailure(t *testing.T) {
+	var calls atomic.Int32
+	handler := func(packet *JsonPacket) error {
+		if calls.Add(1) == 1 {
+			return errors.New("database is down")
+		}
+		return nil
+	}
+	addr := StartTestServer(t, NewTestServer(handler, false))
+	testPacket := GetTestPacket(1)
+	conn := DialTestServer(t, addr)
+	conn.Write(testPacket)
+	if got := len(ReadTestResponses(conn, 500*time.Millisecond)); got != 0 {
+		t.Fatalf("got %d responses to the failed request, want 0", got)
+	}
+	conn.Write(testPacket)
+	if got := len(ReadTestResponses(conn, 500*time.Millisecond)); got != 1 {
+		t.Errorf("got %d responses to the retransmission, want 1", got)
+	}
+	if got := calls.Load(); got != 2 {
+		t.Errorf("handler called %d times, want 2", got)
+	}
+}
+
+func TestDroppedRequestNotRetried(t *testing.T) {
+	var calls atomic.Int32
+	handler := func(packet *JsonPacket) error {
+		calls.Add(1)
+		return ErrDrop
+	}
+	addr := StartTestServer(t, NewTestServer(handler, false))
+	testPacket := GetTestPacket(1)
+	conn := DialTestServer(t, addr)
+	conn.Write(testPacket)
+	time.Sleep(100 * time.Millisecond)
+	conn.Write(testPacket)
+	if got := len(ReadTestResponses(conn, 500*time.Millisecond)); got != 0 {
+		t.Errorf("got %d responses to a dropped request, want 0", got)
+	}
+	if got := calls.Load(); got != 1 {
+		t.Errorf("handler called %d times, want 1", got)
+	}
 }
 
 func TestManyRequest(t *testing.T) {
 	handler := func(packet *JsonPacket) error {
 		return nil
 	}
-	StartTestServer(handler, false)
+	addr := StartTestServer(t, NewTestServer(handler, false))
 	wg := new(sync.WaitGroup)
 	wg.Add(50)
 	for i := 0; i < 50; i++ {
@@ -192,7 +377,7 @@ func TestManyRequest(t *testing.T) {
 			defer wg.Done()
 			id := byte(i)
 			testPacket := GetTestPacket(id)
-			response, err := ExchangePacket(context.Background(), testPacket, "localhost:1813")
+			response, err := ExchangePacket(context.Background(), testPacket, addr)
 			if err != nil {
 				t.Errorf(err.Error())
 			}
@@ -209,7 +394,7 @@ func TestAllowRetrans(t *testing.T) {
 	handler := func(packet *JsonPacket) error {
 		return nil
 	}
-	StartTestServer(handler, true)
+	addr := StartTestServer(t, NewTestServer(handler, true))
 	wg := new(sync.WaitGroup)
 	wg.Add(5)
 	for i := 0; i < 5; i++ {
@@ -217,7 +402,7 @@ func TestAllowRetrans(t *testing.T) {
 			defer wg.Done()
 			id := byte(1)
 			testPacket := GetTestPacket(id)
-			response, err := ExchangePacket(context.Background(), testPacket, "localhost:1813")
+			response, err := ExchangePacket(context.Background(), testPacket, addr)
 			if err != nil {
 				t.Errorf(err.Error())
 			}
@@ -232,32 +417,40 @@ func TestAllowRetrans(t *testing.T) {
 }
 
 func TestShutdownRequest(t *testing.T) {
-	reqs := make([]JsonPacket, 50)
+	var mu sync.Mutex
+	reqs := make([]JsonPacket, 0, 3)
 	handler := func(packet *JsonPacket) error {
 		time.Sleep(5 * time.Second)
+		mu.Lock()
+		defer mu.Unlock()
 		reqs = append(reqs, *packet)
 		return nil
 	}
 	wg := new(sync.WaitGroup)
+	wg.Add(4)
+	port, addr := NextTestAddr()
 	server := &PacketServer{
-		Port:          1813,
+		Port:          port,
 		Secret:        "secret",
 		HandleRequest: handler,
 		LogLevel:      Trace,
 	}
+	// Serve is called directly, it has to return once the requests are handled
+	if err := server.Listen(); err != nil {
+		t.Fatalf("error starting server: %v", err)
+	}
 	go func(server *PacketServer) {
 		defer wg.Done()
 		if err := server.Serve(); err != nil {
 			panic("error starting server: " + err.Error())
 		}
 	}(server)
-	time.Sleep(100 * time.Millisecond)
 	for i := 0; i < 3; i++ {
 		go func(i int) {
 			defer wg.Done()
 			id := byte(i)
 			testPacket := GetTestPacket(id)
-			response, err := ExchangePacket(context.Background(), testPacket, "localhost:1813")
+			response, err := ExchangePacket(context.Background(), testPacket, addr)
 			if err != nil {
 				t.Errorf(err.Error())
 			}
@@ -267,9 +460,427 @@ func TestShutdownRequest(t *testing.T) {
 			}
 		}(i)
 	}
+	time.Sleep(1 * time.Second)
 	go server.Shutdown()
 	wg.Wait()
-	if len(reqs) != 50 {
-		t.Errorf("reqs = %q, want %q", len(reqs), 50)
+	if len(reqs) != 3 {
+		t.Errorf("reqs = %d, want %d", len(reqs), 3)
+	}
+}
+
+func TestShutdownBeforeServe(t *testing.T) {
+	port, _ := NextTestAddr()
+	server := &PacketServer{
+		Port:          port,
+		Secret:        "secret",
+		HandleRequest: func(packet *JsonPacket) error { return nil },
+	}
+	server.Shutdown()
+	if err := server.Serve(); err == nil {
+		t.Error("Serve() of a shut down server returned no error")
+	}
+	server.Shutdown()
+}
+
+func TestListen(t *testing.T) {
+	port, addr := NextTestAddr()
+	server := &PacketServer{
+		Port:          port,
+		Secret:        "secret",
+		HandleRequest: func(packet *JsonPacket) error { return nil },
+	}
+	if err := server.Listen(); err != nil {
+		t.Fatalf("Listen() failed with: %v", err)
+	}
+	done := make(chan error)
+	go func() { done <- server.Serve() }()
+	// no delay needed, the socket is bound once Listen returned
+	if _, err := ExchangePacket(context.Background(), GetTestPacket(1), addr); err != nil {
+		t.Errorf("ExchangePacket failed with: %v", err)
+	}
+	server.Shutdown()
+	if err := <-done; err != nil {
+		t.Errorf("Serve() = %v after Shutdown", err)
+	}
+}
+
+func TestInvalidAuthenticator(t *testing.T) {
+	var handled atomic.Bool
+	handler := func(packet *JsonPacket) error {
+		handled.Store(true)
+		return nil
+	}
+	server := NewTestServer(handler, false)
+	addr := StartTestServer(t, server)
+	testPacket := GetTestPacket(1)
+	SignTestPacket(testPacket, "wrong-secret")
+	c, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+	_, err := ExchangePacket(c, testPacket, addr)
+	if err == nil || err.Error() != "context deadline exceeded" {
+		t.Errorf("err = %v, want (context deadline exceeded)", err)
+	}
+	if handled.Load() {
+		t.Errorf("handler was called for a packet with an invalid authenticator")
+	}
+	if got := server.GetInvalidAuthenticatorCount(); got != 1 {
+		t.Errorf("GetInvalidAuthenticatorCount() = %d, want %d", got, 1)
+	}
+}
+
+func TestShortPacket(t *testing.T) {
+	var handled atomic.Int32
+	handler := func(packet *JsonPacket) error {
+		handled.Add(1)
+		return nil
+	}
+	server := NewTestServer(handler, false)
+	addr := StartTestServer(t, server)
+	conn := DialTestServer(t, addr)
+	testPacket := GetTestPacket(1)
+	for _, b := range [][]byte{{}, {4}, testPacket[:19], testPacket[:len(testPacket)-1]} {
+		conn.Write(b)
+	}
+	conn.Write(testPacket)
+	if responses := ReadTestResponses(conn, 500*time.Millisecond); len(responses) != 1 {
+		t.Errorf("got %d responses, want %d", len(responses), 1)
+	}
+	if got := handled.Load(); got != 1 {
+		t.Errorf("handler called %d times, want %d", got, 1)
+	}
+	if got := server.GetMalformedPacketCount(); got != 4 {
+		t.Errorf("GetMalformedPacketCount() = %d, want %d", got, 4)
+	}
+}
+
+func TestSkipAuthenticatorCheck(t *testing.T) {
+	handler := func(packet *JsonPacket) error {
+		return nil
+	}
+	server := &PacketServer{
+		Secret:                 "secret",
+		HandleRequest:          handler,
+		SkipAuthenticatorCheck: true,
+	}
+	addr := StartTestServer(t, server)
+	testPacket := GetTestPacket(1)
+	copy(testPacket[4:20], make([]byte, 16))
+	response, err := ExchangePacket(context.Background(), testPacket, addr)
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	if response != CodeAccountingResponse {
+		t.Errorf("response.Code = %q, want %q", response, CodeAccountingResponse)
+	}
+}
+
+// NewClientTableServer returns a server with a client table of the given clients
+func NewClientTableServer(f func(packet *JsonPacket) error, clients ...Client) *PacketServer {
+	table := NewClientTable()
+	for _, c := range clients {
+		table.Add(c)
+	}
+	return &PacketServer{
+		Clients:       table,
+		HandleRequest: f,
+	}
+}
+
+func TestClientTable(t *testing.T) {
+	var gotClient atomic.Value
+	handler := func(packet *JsonPacket) error {
+		gotClient.Store(packet.Client)
+		return nil
+	}
+	addr := StartTestServer(t, NewClientTableServer(handler,
+		Client{Network: "127.0.0.0/8", Name: "local-v4", Secret: "secret"},
+		Client{Network: "::1", Name: "local-v6", Secret: "secret"},
+	))
+	response, err := ExchangePacket(context.Background(), GetTestPacket(1), addr)
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	if response != CodeAccountingResponse {
+		t.Errorf("response.Code = %q, want %q", response, CodeAccountingResponse)
+	}
+	if got, _ := gotClient.Load().(string); got != "local-v4" && got != "local-v6" {
+		t.Errorf("Client = %q, want local-v4 or local-v6", got)
+	}
+}
+
+func TestUnknownClient(t *testing.T) {
+	handler := func(packet *JsonPacket) error {
+		return nil
+	}
+	server := NewClientTableServer(handler, Client{Network: "10.0.0.0/8", Name: "remote", Secret: "secret"})
+	addr := StartTestServer(t, server)
+	c, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+	_, err := ExchangePacket(c, GetTestPacket(1), addr)
+	if err == nil || err.Error() != "context deadline exceeded" {
+		t.Errorf("err = %v, want (context deadline exceeded)", err)
+	}
+	if got := server.GetUnknownClientCount(); got != 1 {
+		t.Errorf("GetUnknownClientCount() = %d, want %d", got, 1)
+	}
+}
+
+func TestClientNasIdentifierMismatch(t *testing.T) {
+	handler := func(packet *JsonPacket) error {
+		return nil
+	}
+	addr := StartTestServer(t, NewClientTableServer(handler,
+		Client{Network: "127.0.0.1", Name: "local-v4", Secret: "secret", NasIdentifier: "other"},
+		Client{Network: "::1", Name: "local-v6", Secret: "secret", NasIdentifier: "other"},
+	))
+	c, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+	_, err := ExchangePacket(c, GetTestPacket(1), addr)
+	if err == nil || err.Error() != "context deadline exceeded" {
+		t.Errorf("err = %v, want (context deadline exceeded)", err)
+	}
+}
+
+func GetStatusServerPacket(id byte, secret string) []byte {
+	packet := &RequestPacket{
+		Code:       CodeStatusServer,
+		Identifier: id,
+		Secret:     []byte(secret),
+		Attributes: []RadiusAttribute{newMessageAuthenticator()},
+	}
+	copy(packet.Authenticator[:], "status-check-123")
+	b, err := packet.Encode()
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func TestStatusServer(t *testing.T) {
+	var fail atomic.Bool
+	handler := func(packet *JsonPacket) error {
+		if fail.Load() {
+			return fmt.Errorf("database down")
+		}
+		return nil
+	}
+	addr := StartTestServer(t, NewTestServer(handler, true))
+	response, err := ExchangePacket(context.Background(), GetStatusServerPacket(1, "secret"), addr)
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	if response != CodeAccountingResponse {
+		t.Errorf("response.Code = %q, want %q", response, CodeAccountingResponse)
+	}
+	fail.Store(true)
+	c, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+	ExchangePacket(c, GetTestPacket(1), addr)
+	c, cancel = context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+	if _, err := ExchangePacket(c, GetStatusServerPacket(2, "secret"), addr); err == nil {
+		t.Errorf("status server answered while the handler is failing")
+	}
+	fail.Store(false)
+	if _, err := ExchangePacket(context.Background(), GetTestPacket(1), addr); err != nil {
+		t.Errorf(err.Error())
+	}
+	if _, err := ExchangePacket(context.Background(), GetStatusServerPacket(3, "secret"), addr); err != nil {
+		t.Errorf(err.Error())
+	}
+}
+
+func TestStatusServerRecovers(t *testing.T) {
+	server := &PacketServer{
+		Secret:                "secret",
+		AllowRetransmission:   true,
+		HandlerFailureSeconds: 1,
+		HandleRequest: func(packet *JsonPacket) error {
+			return fmt.Errorf("database down")
+		},
+	}
+	addr := StartTestServer(t, server)
+	c, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	ExchangePacket(c, GetTestPacket(1), addr)
+	c, cancel = context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	if _, err := ExchangePacket(c, GetStatusServerPacket(1, "secret"), addr); err == nil {
+		t.Errorf("status server answered while the handler is failing")
+	}
+	// no accounting request is sent, the failure expires by itself
+	time.Sleep(time.Second)
+	if _, err := ExchangePacket(context.Background(), GetStatusServerPacket(2, "secret"), addr); err != nil {
+		t.Errorf("status server not answered after the failure expired: %v", err)
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	var healthy atomic.Bool
+	server := &PacketServer{
+		Secret:        "secret",
+		HandleRequest: func(packet *JsonPacket) error { return nil },
+		HealthCheck:   healthy.Load,
+	}
+	addr := StartTestServer(t, server)
+	c, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	if _, err := ExchangePacket(c, GetStatusServerPacket(1, "secret"), addr); err == nil {
+		t.Errorf("status server answered while the health check fails")
+	}
+	healthy.Store(true)
+	if _, err := ExchangePacket(context.Background(), GetStatusServerPacket(2, "secret"), addr); err != nil {
+		t.Errorf("status server not answered: %v", err)
+	}
+}
+
+func TestStatusServerInvalidMessageAuthenticator(t *testing.T) {
+	handler := func(packet *JsonPacket) error {
+		return nil
+	}
+	addr := StartTestServer(t, NewTestServer(handler, true))
+	c, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+	if _, err := ExchangePacket(c, GetStatusServerPacket(1, "wrong-secret"), addr); err == nil {
+		t.Errorf("status server with invalid message authenticator answered")
+	}
+}
+
+// GetTestPacketWithMessageAuthenticator returns the test packet with a Message-Authenticator signed with secret
+func GetTestPacketWithMessageAuthenticator(id byte, secret string) []byte {
+	b, err := NewTestPacket(id, secret, newMessageAuthenticator()).Encode()
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func TestMessageAuthenticator(t *testing.T) {
+	handler := func(packet *JsonPacket) error {
+		return nil
+	}
+	addr := StartTestServer(t, NewTestServer(handler, true))
+	proxyState := RadiusAttribute{Type: 33, Length: 9, Value: []byte("proxy-1")}
+	testPacket, err := NewTestPacket(1, "secret", proxyState, newMessageAuthenticator()).Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := ExchangeRawPacket(context.Background(), testPacket, addr)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	response, err := ParsePacket(res, []byte("secret"))
+	if err != nil {
+		t.Fatalf("unable to parse response: %v", err)
+	}
+	copy(response.Authenticator[:], testPacket[4:20])
+	if !response.VerifyMessageAuthenticator() {
+		t.Errorf("response has no valid message authenticator")
+	}
+	if len(response.Attributes) != 2 || response.Attributes[0].Type != typeMessageAuthenticator {
+		t.Errorf("response attributes = %v, want the Message-Authenticator first", response.Attributes)
+	}
+}
+
+func TestInvalidMessageAuthenticator(t *testing.T) {
+	handler := func(packet *JsonPacket) error {
+		return nil
+	}
+	server := NewTestServer(handler, true)
+	addr := StartTestServer(t, server)
+	testPacket := GetTestPacketWithMessageAuthenticator(1, "wrong-secret")
+	SignTestPacket(testPacket, "secret")
+	c, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+	if _, err := ExchangePacket(c, testPacket, addr); err == nil {
+		t.Errorf("packet with invalid message authenticator answered")
+	}
+	if got := server.GetInvalidAuthenticatorCount(); got != 1 {
+		t.Errorf("GetInvalidAuthenticatorCount() = %d, want %d", got, 1)
+	}
+}
+
+func TestRequireMessageAuthenticator(t *testing.T) {
+	handler := func(packet *JsonPacket) error {
+		return nil
+	}
+	server := &PacketServer{
+		Secret:                      "secret",
+		HandleRequest:               handler,
+		AllowRetransmission:         true,
+		RequireMessageAuthenticator: true,
+	}
+	addr := StartTestServer(t, server)
+	c, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+	if _, err := ExchangePacket(c, GetTestPacket(1), addr); err == nil {
+		t.Errorf("packet without message authenticator answered")
+	}
+	if _, err := ExchangePacket(context.Background(), GetTestPacketWithMessageAuthenticator(1, "secret"), addr); err != nil {
+		t.Errorf(err.Error())
+	}
+}
+
+// AppendTestAttribute adds an attribute to the packet b and signs it again
+func AppendTestAttribute(b []byte, t uint8, v []byte) []byte {
+	b = append(b, t, uint8(2+len(v)))
+	b = append(b, v...)
+	binary.BigEndian.PutUint16(b[2:4], uint16(len(b)))
+	SignTestPacket(b, "secret")
+	return b
+}
+
+func TestProxyStateEcho(t *testing.T) {
+	handler := func(packet *JsonPacket) error {
+		return nil
+	}
+	addr := StartTestServer(t, NewTestServer(handler, true))
+	testPacket := AppendTestAttribute(GetTestPacket(1), 33, []byte("proxy-1"))
+	testPacket = AppendTestAttribute(testPacket, 33, []byte("proxy-2"))
+	res, err := ExchangeRawPacket(context.Background(), testPacket, addr)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	response, err := ParsePacket(res, []byte("secret"))
+	if err != nil {
+		t.Fatalf("unable to parse response: %v", err)
+	}
+	var got []string
+	for _, attr := range response.Attributes {
+		if attr.Type == 33 {
+			got = append(got, string(attr.Value))
+		}
+	}
+	if len(got) != 2 || got[0] != "proxy-1" || got[1] != "proxy-2" {
+		t.Errorf("Proxy-State = %q, want %q", got, []string{"proxy-1", "proxy-2"})
+	}
+}
+
+func TestHandleRequestWithReply(t *testing.T) {
+	handler := func(packet *JsonPacket) ([]RadiusAttribute, error) {
+		return []RadiusAttribute{{Type: 25, Value: []byte("reply-class")}}, nil
+	}
+	server := &PacketServer{
+		Secret:                 "secret",
+		HandleRequestWithReply: handler,
+	}
+	addr := StartTestServer(t, server)
+	testPacket := AppendTestAttribute(GetTestPacket(1), 33, []byte("proxy-1"))
+	res, err := ExchangeRawPacket(context.Background(), testPacket, addr)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	response, err := ParsePacket(res, []byte("secret"))
+	if err != nil {
+		t.Fatalf("unable to parse response: %v", err)
+	}
+	if len(response.Attributes) != 2 {
+		t.Fatalf("got %d attributes, want %d", len(response.Attributes), 2)
+	}
+	if got := string(response.Attributes[0].Value); response.Attributes[0].Type != 25 || got != "reply-class" {
+		t.Errorf("Class = %q, want %q", got, "reply-class")
+	}
+	if got := string(response.Attributes[1].Value); response.Attributes[1].Type != 33 || got != "proxy-1" {
+		t.Errorf("Proxy-State = %q, want %q", got, "proxy-1")
 	}
 }
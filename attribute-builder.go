@@ -0,0 +1,176 @@
+package accter
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxAttributeValue is the maximum length of an attribute value, the length field includes the header
+const maxAttributeValue = 253
+
+/*
+ * NewAttribute builds the attribute with the given name from a Go value. The
+ * value has the type of TypedAttribute.Value for the data type of the
+ * attribute, enumerated values can also be given by name like "Start".
+ * Vendor attributes are wrapped in a Vendor-Specific attribute and extended
+ * attributes in their RFC 6929 format. Long-Extended-Type values which do
+ * not fit into one attribute are split into fragments by Encode. Salt
+ * encrypted values are not encrypted, see DecryptSalted.
+ */
+func NewAttribute(name string, value interface{}) (RadiusAttribute, error) {
+	return newAttribute(name, 0, false, value)
+}
+
+// NewTaggedAttribute builds a tagged attribute as described in RFC 2868 section 3
+func NewTaggedAttribute(name string, tag uint8, value interface{}) (RadiusAttribute, error) {
+	if tag == 0 || tag > 0x1f {
+		return RadiusAttribute{}, fmt.Errorf("invalid tag %d", tag)
+	}
+	return newAttribute(name, tag, true, value)
+}
+
+func newAttribute(name string, tag uint8, tagged bool, value interface{}) (RadiusAttribute, error) {
+	attr, ok := lookupAttribute(name)
+	if !ok {
+		return RadiusAttribute{}, fmt.Errorf("unknown attribute %q", name)
+	}
+	if tagged && !attr.helper.Tagged {
+		return RadiusAttribute{}, fmt.Errorf("attribute %q has no tag", name)
+	}
+	b, err := attr.helper.encode(value)
+	if err != nil {
+		return RadiusAttribute{}, fmt.Errorf("%s: %v", name, err)
+	}
+	if tagged {
+		if attr.helper.Type == TypeInteger {
+			if b[0] != 0 {
+				return RadiusAttribute{}, fmt.Errorf("%s: value out of range for a tagged integer", name)
+			}
+			b[0] = tag
+		} else {
+			b = append([]byte{tag}, b...)
+		}
+	}
+	return attr.wrap(b)
+}
+
+// encode returns the attribute value of v, enumerated values are looked up by name
+func (a AttributeHelper) encode(v interface{}) ([]byte, error) {
+	if s, ok := v.(string); ok && a.Values != nil {
+		for n, name := range a.Values {
+			if name == s {
+				return a.Type.encodeUint(n)
+			}
+		}
+		return nil, fmt.Errorf("unknown value %q", s)
+	}
+	return a.Type.encode(v)
+}
+
+// attributeLocation is where an attribute is defined: the standard, a vendor or the extended space
+type attributeLocation struct {
+	helper   AttributeHelper
+	typ      int
+	vendorId uint32
+	extended string
+}
+
+// lookupAttribute finds an attribute by name in Attributes, Vendors and ExtendedAttributes
+func lookupAttribute(name string) (attributeLocation, bool) {
+	for typ, attr := range Attributes {
+		if attr.Name == name {
+			return attributeLocation{helper: attr, typ: typ}, true
+		}
+	}
+	for vendorId, vendor := range Vendors {
+		for typ, attr := range vendor.Attributes {
+			if attr.Name == name {
+				return attributeLocation{helper: attr, typ: typ, vendorId: vendorId}, true
+			}
+		}
+	}
+	for number, attr := range ExtendedAttributes {
+		if attr.Name == name {
+			return attributeLocation{helper: attr, extended: number}, true
+		}
+	}
+	return attributeLocation{}, false
+}
+
+// wrap puts the value b into the attribute format of its location
+func (l attributeLocation) wrap(b []byte) (RadiusAttribute, error) {
+	switch {
+	case l.extended != "":
+		return wrapExtended(l.extended, b)
+	case l.vendorId != 0:
+		return wrapVendorSpecific(l.vendorId, l.typ, b)
+	}
+	return newRadiusAttribute(uint8(l.typ), b)
+}
+
+func newRadiusAttribute(t uint8, b []byte) (RadiusAttribute, error) {
+	if len(b) > maxAttributeValue {
+		return RadiusAttribute{}, fmt.Errorf("attribute %d is too long", t)
+	}
+	return RadiusAttribute{Type: t, Length: uint8(2 + len(b)), Value: b}, nil
+}
+
+// wrapVendorSpecific builds a Vendor-Specific attribute with the type and length sizes of the vendor
+func wrapVendorSpecific(vendorId uint32, typ int, b []byte) (RadiusAttribute, error) {
+	vendor := Vendors[vendorId]
+	typeSize, lengthSize, header := vendor.format()
+	value := binary.BigEndian.AppendUint32(nil, vendorId)
+	value = appendVendorField(value, typeSize, uint32(typ))
+	if lengthSize > 0 {
+		value = appendVendorField(value, lengthSize, uint32(header+len(b)))
+	}
+	if vendor.Continuation {
+		// the value is never continued, it has to fit into one Vendor-Specific
+		value = append(value, 0)
+	}
+	return newRadiusAttribute(typeVendorSpecific, append(value, b...))
+}
+
+func appendVendorField(b []byte, size int, n uint32) []byte {
+	switch size {
+	case 1:
+		return append(b, byte(n))
+	case 2:
+		return binary.BigEndian.AppendUint16(b, uint16(n))
+	case 4:
+		return binary.BigEndian.AppendUint32(b, n)
+	}
+	return b
+}
+
+/*
+ * wrapExtended builds an extended attribute from its dotted number. The value
+ * of a Long-Extended-Type attribute may exceed one attribute, its Length is
+ * 0 then and the fragments are built by Encode.
+ */
+func wrapExtended(number string, b []byte) (RadiusAttribute, error) {
+	var parts []int
+	for _, part := range strings.Split(number, ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return RadiusAttribute{}, fmt.Errorf("invalid extended attribute number %q", number)
+		}
+		parts = append(parts, n)
+	}
+	t := uint8(parts[0])
+	value := []byte{uint8(parts[1])}
+	if isLongExtended(t) {
+		value = append(value, 0)
+	}
+	if len(parts) == 4 {
+		value = binary.BigEndian.AppendUint32(value, uint32(parts[2]))
+		value = append(value, uint8(parts[3]))
+	}
+	value = append(value, b...)
+	if isLongExtended(t) && len(value) > maxAttributeValue {
+		return RadiusAttribute{Type: t, Value: value}, nil
+	}
+	return newRadiusAttribute(t, value)
+}
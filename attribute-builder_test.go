@@ -0,0 +1,263 @@
+package accter
+
+import (
+	"bytes"
+	"net"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testValues are values of every data type as returned by TypedAttribute.Value
+var testValues = map[DataType]interface{}{
+	TypeString:     "value",
+	TypeOctets:     []byte{1, 2, 3},
+	TypeInteger:    uint32(7),
+	TypeIPAddr:     net.IPv4(10, 0, 0, 1).To4(),
+	TypeIPv6Addr:   net.ParseIP("2001:db8::1"),
+	TypeIPv6Prefix: netip.MustParsePrefix("2001:db8:1200::/40"),
+	TypeIfId:       "1:2:3:abcd",
+	TypeDate:       time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC),
+	TypeInteger64:  uint64(1 << 40),
+	TypeByte:       uint32(8),
+	TypeShort:      uint32(1000),
+}
+
+func TestDataTypeRoundTrip(t *testing.T) {
+	for typ, want := range testValues {
+		b, err := typ.encode(want)
+		if err != nil {
+			t.Errorf("%s: encode failed with: %v", typ, err)
+			continue
+		}
+		got, err := typ.decode(b)
+		if err != nil {
+			t.Errorf("%s: decode failed with: %v", typ, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %v, want %v", typ, got, want)
+		}
+	}
+}
+
+// TestEncodeRoundTrip builds every known attribute and parses the encoded packet again
+func TestEncodeRoundTrip(t *testing.T) {
+	var names []string
+	for typ, attr := range Attributes {
+		if uint8(typ) != typeVendorSpecific && uint8(typ) != typeMessageAuthenticator {
+			names = append(names, attr.Name)
+		}
+	}
+	for _, vendor := range Vendors {
+		for _, attr := range vendor.Attributes {
+			names = append(names, attr.Name)
+		}
+	}
+	for _, attr := range ExtendedAttributes {
+		names = append(names, attr.Name)
+	}
+	packet := &RequestPacket{Code: CodeAccountingRequest, Identifier: 7, Secret: []byte("secret")}
+	var want []interface{}
+	for _, name := range names {
+		location, _ := lookupAttribute(name)
+		value := testValues[location.helper.Type]
+		attr, err := NewAttribute(name, value)
+		if err != nil {
+			t.Fatalf("NewAttribute(%s) failed with: %v", name, err)
+		}
+		packet.Attributes = append(packet.Attributes, attr)
+		want = append(want, value)
+	}
+	b, err := packet.Encode()
+	if err != nil {
+		t.Fatalf("Encode failed with: %v", err)
+	}
+	parsed, err := ParsePacket(b, []byte("secret"))
+	if err != nil {
+		t.Fatalf("ParsePacket failed with: %v", err)
+	}
+	if !parsed.VerifyAuthenticator() {
+		t.Error("invalid Request Authenticator")
+	}
+	if int(parsed.Length) != len(b) {
+		t.Errorf("Length = %d, want %d", parsed.Length, len(b))
+	}
+	typed := NewTypedPacket(parsed)
+	if len(typed.Attributes) != len(names) {
+		t.Fatalf("got %d attributes, want %d", len(typed.Attributes), len(names))
+	}
+	for i, attr := range typed.Attributes {
+		if attr.Name != names[i] || !reflect.DeepEqual(attr.Value, want[i]) {
+			t.Errorf("got %s=%v, want %s=%v", attr.Name, attr.Value, names[i], want[i])
+		}
+	}
+}
+
+func TestNewAttribute(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+		want  RadiusAttribute
+	}{
+		{"Acct-Status-Type", "Start", RadiusAttribute{Type: 40, Length: 6, Value: []byte{0, 0, 0, 1}}},
+		{"Acct-Status-Type", 3, RadiusAttribute{Type: 40, Length: 6, Value: []byte{0, 0, 0, 3}}},
+		{"NAS-IP-Address", netip.MustParseAddr("1.2.3.4"), RadiusAttribute{Type: 4, Length: 6, Value: []byte{1, 2, 3, 4}}},
+		{"Cisco-AVPair", "a=b", RadiusAttribute{Type: 26, Length: 11, Value: []byte{0, 0, 0, 9, 1, 5, 'a', '=', 'b'}}},
+		{"SN-VPN-ID", uint32(1), RadiusAttribute{Type: 26, Length: 14, Value: []byte{0, 0, 0x1f, 0xe4, 0, 1, 0, 8, 0, 0, 0, 1}}},
+		{"Frag-Status", uint32(2), RadiusAttribute{Type: 241, Length: 7, Value: []byte{1, 0, 0, 0, 2}}},
+		{"SAML-Assertion", "x", RadiusAttribute{Type: 245, Length: 5, Value: []byte{1, 0, 'x'}}},
+	}
+	for _, test := range tests {
+		got, err := NewAttribute(test.name, test.value)
+		if err != nil {
+			t.Errorf("NewAttribute(%s) failed with: %v", test.name, err)
+			continue
+		}
+		if got.Type != test.want.Type || got.Length != test.want.Length || !bytes.Equal(got.Value, test.want.Value) {
+			t.Errorf("got %v, want %v", got, test.want)
+		}
+	}
+}
+
+func TestNewTaggedAttribute(t *testing.T) {
+	attr, err := NewTaggedAttribute("Tunnel-Type", 2, "L2TP")
+	if err != nil || !bytes.Equal(attr.Value, []byte{2, 0, 0, 3}) {
+		t.Errorf("got %v, %v, want Tunnel-Type with tag 2 and L2TP", attr, err)
+	}
+	attr, err = NewTaggedAttribute("Tunnel-Server-Endpoint", 1, "10.0.0.1")
+	if err != nil || !bytes.Equal(attr.Value, []byte("\x0110.0.0.1")) {
+		t.Errorf("got %v, %v, want Tunnel-Server-Endpoint with tag 1", attr, err)
+	}
+	if _, err := NewTaggedAttribute("User-Name", 1, "user"); err == nil {
+		t.Error("tag accepted for untagged attribute")
+	}
+	if _, err := NewTaggedAttribute("Tunnel-Type", 0x20, "L2TP"); err == nil {
+		t.Error("invalid tag accepted")
+	}
+}
+
+func TestNewAttributeFail(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+	}{
+		{"Unknown-Attribute", "x"},
+		{"User-Name", 1},
+		{"NAS-Port", -1},
+		{"NAS-Port", uint64(1 << 32)},
+		{"Acct-Status-Type", "Unknown"},
+		{"NAS-IP-Address", net.ParseIP("2001:db8::1")},
+		{"Framed-Interface-Id", "1:2"},
+		{"User-Name", strings.Repeat("a", 254)},
+	}
+	for _, test := range tests {
+		if got, err := NewAttribute(test.name, test.value); err == nil {
+			t.Errorf("NewAttribute(%s, %v) = %v, want an error", test.name, test.value, got)
+		}
+	}
+}
+
+func TestEncodeResponse(t *testing.T) {
+	request := &RequestPacket{Code: CodeAccountingRequest, Identifier: 9, Secret: []byte("secret")}
+	b, err := request.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(request.Authenticator[:], b[4:20])
+	response := &RequestPacket{
+		Code:          CodeAccountingResponse,
+		Identifier:    request.Identifier,
+		Secret:        request.Secret,
+		Authenticator: request.Authenticator,
+		Attributes:    []RadiusAttribute{newMessageAuthenticator()},
+	}
+	r, err := response.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckAuthenticator(b[4:20], r); err != nil {
+		t.Error(err)
+	}
+	parsed, err := ParsePacket(r, request.Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed.Authenticator = request.Authenticator
+	if !parsed.VerifyMessageAuthenticator() {
+		t.Error("invalid Message-Authenticator")
+	}
+}
+
+func TestEncodeStatusServer(t *testing.T) {
+	packet := &RequestPacket{
+		Code:          CodeStatusServer,
+		Identifier:    1,
+		Secret:        []byte("secret"),
+		Authenticator: [16]byte{1, 2, 3},
+		Attributes:    []RadiusAttribute{newMessageAuthenticator()},
+	}
+	b, err := packet.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b[4:20], packet.Authenticator[:]) {
+		t.Errorf("authenticator changed to %x", b[4:20])
+	}
+	parsed, err := ParsePacket(b, packet.Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !parsed.VerifyMessageAuthenticator() {
+		t.Error("invalid Message-Authenticator")
+	}
+}
+
+func TestEncodeInvalidMessageAuthenticator(t *testing.T) {
+	for _, value := range [][]byte{nil, make([]byte, 15), make([]byte, 17)} {
+		packet := &RequestPacket{
+			Code:       CodeAccountingRequest,
+			Identifier: 1,
+			Secret:     []byte("secret"),
+			Attributes: []RadiusAttribute{{Type: typeMessageAuthenticator, Value: value}},
+		}
+		if b, err := packet.Encode(); err == nil {
+			t.Errorf("Encode() with a %d byte Message-Authenticator = %x, want an error", len(value), b)
+		}
+	}
+}
+
+func TestEncodeLongExtended(t *testing.T) {
+	assertion := strings.Repeat("saml", 100)
+	attr, err := NewAttribute("SAML-Assertion", assertion)
+	if err != nil {
+		t.Fatalf("NewAttribute failed with: %v", err)
+	}
+	packet := &RequestPacket{Code: CodeAccountingRequest, Identifier: 1, Secret: []byte("secret"), Attributes: []RadiusAttribute{attr}}
+	b, err := packet.Encode()
+	if err != nil {
+		t.Fatalf("Encode failed with: %v", err)
+	}
+	parsed, err := ParsePacket(b, packet.Secret)
+	if err != nil {
+		t.Fatalf("ParsePacket failed with: %v", err)
+	}
+	if !parsed.VerifyAuthenticator() {
+		t.Error("invalid Request Authenticator")
+	}
+	if len(parsed.Attributes) != 2 {
+		t.Fatalf("got %d fragments, want %d", len(parsed.Attributes), 2)
+	}
+	if first := parsed.Attributes[0]; first.Length != 255 || first.Value[0] != 1 || first.Value[1] != flagMore {
+		t.Errorf("first fragment = %d bytes with flags %#x, want 255 bytes with the More flag", first.Length, first.Value[1])
+	}
+	if last := parsed.Attributes[1]; last.Value[1] != 0 {
+		t.Errorf("last fragment has flags %#x, want 0", last.Value[1])
+	}
+	typed := NewTypedPacket(parsed)
+	if len(typed.Attributes) != 1 || typed.Attributes[0].Value != assertion {
+		t.Errorf("got %v, want SAML-Assertion of %d bytes", typed.Attributes, len(assertion))
+	}
+}
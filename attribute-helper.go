@@ -2,71 +2,235 @@ package accter
 
 import (
 	"encoding/binary"
+	"encoding/hex"
 	"fmt"
 	"net"
+	"net/netip"
+	"strconv"
 )
 
 type AttributeHelper struct {
-	Name   string
+	Name string
+	Type DataType
+	// Parser is optional and replaces the parser of the data type
 	Parser func([]byte) string
+	// Values are the names of enumerated integer values
+	Values map[uint64]string
+	// Tagged attributes carry the tag of a tunnel as described in RFC 2868 section 3
+	Tagged bool
+	// Encrypted values are salt encrypted, see DecryptSalted
+	Encrypted bool
 }
 
 var Attributes = map[int]AttributeHelper{
-	1: {"User-Name", parseString},
+	1: {Name: "User-Name", Type: TypeString},
 	//2: 	{"User-Password",parseparseString}, NOT USED IN ACCOUNTING
 	//3: 	{"CHAP-Password",parseparseString}, NOT USED IN ACCOUNTING
-	4:  {"NAS-IP-Address", parseIPAddr},
-	5:  {"NAS-Port", parseInteger},
-	6:  {"Service-Type", parseInteger},
-	7:  {"Framed-Protocol", parseInteger},
-	8:  {"Framed-IP-Address", parseIPAddr},
-	9:  {"Framed-IP-Netmask", parseIPAddr},
-	10: {"Framed-Routing", parseInteger},
-	11: {"Filter-Id", parseString},
-	12: {"Framed-MTU", parseInteger},
-	13: {"Framed-Compression", parseInteger},
-	14: {"Login-IP-Host", parseIPAddr},
-	15: {"Login-Service", parseInteger},
-	16: {"Login-TCP-Port", parseInteger},
+	4:  {Name: "NAS-IP-Address", Type: TypeIPAddr},
+	5:  {Name: "NAS-Port", Type: TypeInteger},
+	6:  {Name: "Service-Type", Type: TypeInteger, Values: serviceTypes},
+	7:  {Name: "Framed-Protocol", Type: TypeInteger, Values: framedProtocols},
+	8:  {Name: "Framed-IP-Address", Type: TypeIPAddr},
+	9:  {Name: "Framed-IP-Netmask", Type: TypeIPAddr},
+	10: {Name: "Framed-Routing", Type: TypeInteger, Values: framedRoutings},
+	11: {Name: "Filter-Id", Type: TypeString},
+	12: {Name: "Framed-MTU", Type: TypeInteger},
+	13: {Name: "Framed-Compression", Type: TypeInteger, Values: framedCompressions},
+	14: {Name: "Login-IP-Host", Type: TypeIPAddr},
+	15: {Name: "Login-Service", Type: TypeInteger, Values: loginServices},
+	16: {Name: "Login-TCP-Port", Type: TypeInteger, Values: loginTCPPorts},
 	//	18: {"Reply-Message",parseString}, NOT USED IN ACCOUNTING
-	19: {"Callback-Number", parseString},
-	20: {"Callback-Id", parseString},
-	22: {"Framed-Route", parseString},
-	23: {"Framed-IPX-Network", parseInteger},
+	19: {Name: "Callback-Number", Type: TypeString},
+	20: {Name: "Callback-Id", Type: TypeString},
+	22: {Name: "Framed-Route", Type: TypeString},
+	23: {Name: "Framed-IPX-Network", Type: TypeInteger},
 	//	24: {"State",parseString}, NOT USED IN ACCOUNTING
-	25: {"Class", parseString},
-	26: {"Vendor-Specific", parseString},
-	27: {"Session-Timeout", parseInteger},
-	28: {"Idle-Timeout", parseInteger},
-	29: {"Termination-Action", parseInteger},
-	30: {"Called-Station-Id", parseString},
-	31: {"Calling-Station-Id", parseString},
-	32: {"NAS-Identifier", parseString},
-	33: {"Proxy-State", parseString},
-	34: {"Login-LAT-Service", parseString},
-	35: {"Login-LAT-Node", parseString},
-	36: {"Login-LAT-Group", parseString},
-	37: {"Framed-AppleTalk-Link", parseInteger},
-	38: {"Framed-AppleTalk-Network", parseInteger},
-	39: {"Framed-AppleTalk-Zone", parseString},
-	40: {"Acct-Status-Type", parseInteger},
-	41: {"Acct-Delay-Time", parseInteger},
-	42: {"Acct-Input-Octets", parseInteger},
-	43: {"Acct-Output-Octets", parseInteger},
-	44: {"Acct-Session-Id", parseString},
-	45: {"Acct-Authentic", parseInteger},
-	46: {"Acct-Session-Time", parseInteger},
-	47: {"Acct-Input-Packets", parseInteger},
-	48: {"Acct-Output-Packets", parseInteger},
-	49: {"Acct-Terminate-Cause", parseInteger},
-	50: {"Acct-Multi-Session-Id", parseString},
-	51: {"Acct-Link-Count", parseInteger},
-	52: {"Acct-Input-Gigawords", parseInteger},
-	53: {"Acct-Output-Gigawords", parseInteger},
+	25: {Name: "Class", Type: TypeString},
+	26: {Name: "Vendor-Specific", Type: TypeOctets},
+	27: {Name: "Session-Timeout", Type: TypeInteger},
+	28: {Name: "Idle-Timeout", Type: TypeInteger},
+	29: {Name: "Termination-Action", Type: TypeInteger, Values: terminationActions},
+	30: {Name: "Called-Station-Id", Type: TypeString},
+	31: {Name: "Calling-Station-Id", Type: TypeString},
+	32: {Name: "NAS-Identifier", Type: TypeString},
+	33: {Name: "Proxy-State", Type: TypeString},
+	34: {Name: "Login-LAT-Service", Type: TypeString},
+	35: {Name: "Login-LAT-Node", Type: TypeString},
+	36: {Name: "Login-LAT-Group", Type: TypeString},
+	37: {Name: "Framed-AppleTalk-Link", Type: TypeInteger},
+	38: {Name: "Framed-AppleTalk-Network", Type: TypeInteger},
+	39: {Name: "Framed-AppleTalk-Zone", Type: TypeString},
+	40: {Name: "Acct-Status-Type", Type: TypeInteger, Values: acctStatusTypes},
+	41: {Name: "Acct-Delay-Time", Type: TypeInteger},
+	42: {Name: "Acct-Input-Octets", Type: TypeInteger},
+	43: {Name: "Acct-Output-Octets", Type: TypeInteger},
+	44: {Name: "Acct-Session-Id", Type: TypeString},
+	45: {Name: "Acct-Authentic", Type: TypeInteger, Values: acctAuthentics},
+	46: {Name: "Acct-Session-Time", Type: TypeInteger},
+	47: {Name: "Acct-Input-Packets", Type: TypeInteger},
+	48: {Name: "Acct-Output-Packets", Type: TypeInteger},
+	49: {Name: "Acct-Terminate-Cause", Type: TypeInteger, Values: acctTerminateCauses},
+	50: {Name: "Acct-Multi-Session-Id", Type: TypeString},
+	51: {Name: "Acct-Link-Count", Type: TypeInteger},
+	52: {Name: "Acct-Input-Gigawords", Type: TypeInteger},
+	53: {Name: "Acct-Output-Gigawords", Type: TypeInteger},
+	55: {Name: "Event-Timestamp", Type: TypeDate},
 	//  60: {"CHAP-Challenge", parseString}, NOT USED IN ACCOUNTING
-	61: {"NAS-Port-Type", parseInteger},
-	62: {"Port-Limit", parseInteger},
-	63: {"Login-LAT-Port", parseString},
+	61:  {Name: "NAS-Port-Type", Type: TypeInteger, Values: nasPortTypes},
+	62:  {Name: "Port-Limit", Type: TypeInteger},
+	63:  {Name: "Login-LAT-Port", Type: TypeString},
+	64:  {Name: "Tunnel-Type", Type: TypeInteger, Values: tunnelTypes, Tagged: true},
+	65:  {Name: "Tunnel-Medium-Type", Type: TypeInteger, Values: tunnelMediumTypes, Tagged: true},
+	66:  {Name: "Tunnel-Client-Endpoint", Type: TypeString, Tagged: true},
+	67:  {Name: "Tunnel-Server-Endpoint", Type: TypeString, Tagged: true},
+	68:  {Name: "Acct-Tunnel-Connection", Type: TypeString, Tagged: true},
+	69:  {Name: "Tunnel-Password", Type: TypeString, Tagged: true, Encrypted: true},
+	72:  {Name: "ARAP-Zone-Access", Type: TypeInteger, Values: arapZoneAccesses},
+	76:  {Name: "Prompt", Type: TypeInteger, Values: prompts},
+	77:  {Name: "Connect-Info", Type: TypeString},
+	80:  {Name: "Message-Authenticator", Type: TypeOctets},
+	81:  {Name: "Tunnel-Private-Group-ID", Type: TypeString, Tagged: true},
+	82:  {Name: "Tunnel-Assignment-ID", Type: TypeString, Tagged: true},
+	83:  {Name: "Tunnel-Preference", Type: TypeInteger, Tagged: true},
+	85:  {Name: "Acct-Interim-Interval", Type: TypeInteger},
+	86:  {Name: "Acct-Tunnel-Packets-Lost", Type: TypeInteger},
+	87:  {Name: "NAS-Port-Id", Type: TypeString},
+	88:  {Name: "Framed-Pool", Type: TypeString},
+	89:  {Name: "Chargeable-User-Identity", Type: TypeString},
+	90:  {Name: "Tunnel-Client-Auth-ID", Type: TypeString, Tagged: true},
+	91:  {Name: "Tunnel-Server-Auth-ID", Type: TypeString, Tagged: true},
+	95:  {Name: "NAS-IPv6-Address", Type: TypeIPv6Addr},
+	96:  {Name: "Framed-Interface-Id", Type: TypeIfId},
+	97:  {Name: "Framed-IPv6-Prefix", Type: TypeIPv6Prefix},
+	98:  {Name: "Login-IPv6-Host", Type: TypeIPv6Addr},
+	99:  {Name: "Framed-IPv6-Route", Type: TypeString},
+	100: {Name: "Framed-IPv6-Pool", Type: TypeString},
+	101: {Name: "Error-Cause", Type: TypeInteger, Values: errorCauses},
+	123: {Name: "Delegated-IPv6-Prefix", Type: TypeIPv6Prefix},
+	168: {Name: "Framed-IPv6-Address", Type: TypeIPv6Addr},
+}
+
+// resolvedAttribute is an attribute value together with the helper describing it
+type resolvedAttribute struct {
+	helper AttributeHelper
+	value  []byte
+	tag    uint8
+	// decrypted is set once the value holds the plain text
+	decrypted bool
+}
+
+/*
+ * resolveAttributes looks up the helpers of all attributes of a packet.
+ * Fragmented Long-Extended-Type attributes resolve to a single entry.
+ */
+func resolveAttributes(attrs []RadiusAttribute) []resolvedAttribute {
+	resolved := make([]resolvedAttribute, 0, len(attrs))
+	for i := 0; i < len(attrs); i++ {
+		if isLongExtended(attrs[i].Type) {
+			if attr, n, err := parseExtendedAttribute(attrs[i:]); err == nil {
+				resolved = append(resolved, resolveExtended(attr))
+				i += n - 1
+				continue
+			}
+		}
+		resolved = append(resolved, resolveAttribute(attrs[i])...)
+	}
+	return resolved
+}
+
+/*
+ * resolveAttribute looks up the helper of a RADIUS attribute. Vendor-Specific
+ * attributes resolve to one entry per vendor attribute, extended attributes
+ * to their dotted number.
+ */
+func resolveAttribute(v RadiusAttribute) []resolvedAttribute {
+	if v.Type == typeVendorSpecific {
+		if attrs, err := resolveVendorSpecific(v.Value); err == nil {
+			return attrs
+		}
+	}
+	if isExtended(v.Type) || isLongExtended(v.Type) {
+		if attr, _, err := parseExtendedAttribute([]RadiusAttribute{v}); err == nil {
+			return []resolvedAttribute{resolveExtended(attr)}
+		}
+	}
+	if attr, ok := Attributes[int(v.Type)]; ok {
+		return []resolvedAttribute{attr.resolve(v.Value)}
+	}
+	unsupported := AttributeHelper{Name: fmt.Sprintf("(UNSUPPORTED) %d", v.Type), Type: TypeOctets, Parser: parseString}
+	return []resolvedAttribute{unsupported.resolve(v.Value)}
+}
+
+// toJsonAttributes converts a RADIUS attribute to its string representation.
+func toJsonAttributes(v RadiusAttribute) []JsonAttribute {
+	resolved := resolveAttribute(v)
+	attrs := make([]JsonAttribute, 0, len(resolved))
+	for _, r := range resolved {
+		attrs = append(attrs, r.toJsonAttribute())
+	}
+	return attrs
+}
+
+/*
+ * resolve splits the tag from the value of tagged attributes. The tag is
+ * the first byte and in the range 0x01 to 0x1F. While:
+ *  - integer values keep three bytes for the value, the tag 0x00 means untagged
+ *  - string values start with the first byte above 0x1F if there is no tag
+ */
+func (a AttributeHelper) resolve(b []byte) resolvedAttribute {
+	r := resolvedAttribute{helper: a, value: b}
+	if !a.Tagged || len(b) == 0 {
+		return r
+	}
+	if a.Type == TypeInteger {
+		if len(b) == 4 {
+			r.tag = b[0]
+			r.value = []byte{0, b[1], b[2], b[3]}
+		}
+		return r
+	}
+	if b[0] <= 0x1f {
+		r.tag = b[0]
+		r.value = b[1:]
+	}
+	return r
+}
+
+// toJsonAttribute converts the value and keeps the tag in JsonAttribute.Tag
+func (r resolvedAttribute) toJsonAttribute() JsonAttribute {
+	attr := r.helper.toJsonAttribute(r.value)
+	attr.Tag = r.tag
+	return attr
+}
+
+// Parse returns the string representation of the attribute value b
+func (a AttributeHelper) Parse(b []byte) string {
+	if a.Parser != nil {
+		return a.Parser(b)
+	}
+	if name, _, ok := a.Enumeration(b); ok {
+		return name
+	}
+	return a.Type.parser()(b)
+}
+
+// Enumeration returns the name and number of an enumerated integer value
+func (a AttributeHelper) Enumeration(b []byte) (string, uint64, bool) {
+	n, ok := a.Type.uint(b)
+	if !ok {
+		return "", 0, false
+	}
+	name, ok := a.Values[n]
+	return name, n, ok
+}
+
+// toJsonAttribute keeps the number of enumerated values in JsonAttribute.Raw
+func (a AttributeHelper) toJsonAttribute(b []byte) JsonAttribute {
+	attr := JsonAttribute{Name: a.Name, Value: a.Parse(b)}
+	if _, n, ok := a.Enumeration(b); ok && a.Parser == nil {
+		attr.Raw = strconv.FormatUint(n, 10)
+	}
+	return attr
 }
 
 func parseInteger(b []byte) string {
@@ -88,3 +252,42 @@ func parseIPAddr(b []byte) string {
 	copy(bs, b)
 	return net.IP(bs).String()
 }
+
+func parseOctets(b []byte) string {
+	return hex.EncodeToString(b)
+}
+
+func parseIPv6Addr(b []byte) string {
+	if len(b) != net.IPv6len {
+		return fmt.Sprintf("Wrong length %d", len(b))
+	}
+	return netip.AddrFrom16(*(*[16]byte)(b)).String()
+}
+
+/*
+ * The IPv6 prefix format is described in RFC 3162 section 2.3:
+ *  - b[0] is reserved
+ *  - b[1] is the prefix length in bits
+ *  - b[2:rest] are the significant bytes of the prefix
+ */
+func parseIPv6Prefix(b []byte) string {
+	if len(b) < 2 || len(b) > 2+net.IPv6len {
+		return fmt.Sprintf("Wrong length %d", len(b))
+	}
+	bits := int(b[1])
+	if bits > 128 {
+		return fmt.Sprintf("Wrong prefix length %d", bits)
+	}
+	var addr [16]byte
+	copy(addr[:], b[2:])
+	return netip.PrefixFrom(netip.AddrFrom16(addr), bits).Masked().String()
+}
+
+func parseInterfaceId(b []byte) string {
+	if len(b) != 8 {
+		return fmt.Sprintf("Wrong length %d", len(b))
+	}
+	return fmt.Sprintf("%x:%x:%x:%x",
+		binary.BigEndian.Uint16(b[0:2]), binary.BigEndian.Uint16(b[2:4]),
+		binary.BigEndian.Uint16(b[4:6]), binary.BigEndian.Uint16(b[6:8]))
+}
@@ -1,6 +1,18 @@
 package accter
 
-import "testing"
+import (
+	_ "embed"
+	"encoding/csv"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// radiusTypes is a copy of the IANA registry of RADIUS attribute types
+// https://www.iana.org/assignments/radius-types/radius-types.xhtml#radius-types-2
+//
+//go:embed testdata/radius-types.csv
+var radiusTypes string
 
 func TestParseIPAddr(t *testing.T) {
 	want := "10.10.10.1"
@@ -41,3 +53,191 @@ func TestParseString(t *testing.T) {
 		t.Errorf("got %s, want %s", got, want)
 	}
 }
+
+func TestParseIPv6Addr(t *testing.T) {
+	want := "2001:db8::1"
+	got := parseIPv6Addr([]byte{0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1})
+	if got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestParseIPv6AddrFail(t *testing.T) {
+	want := "Wrong length 4"
+	got := parseIPv6Addr([]byte{10, 10, 10, 1})
+	if got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestParseIPv6Prefix(t *testing.T) {
+	tests := []struct {
+		in   []byte
+		want string
+	}{
+		{[]byte{0, 56, 0x20, 0x01, 0x0d, 0xb8, 0x12, 0x34, 0x56}, "2001:db8:1234:5600::/56"},
+		{[]byte{0, 64, 0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0}, "2001:db8:0:1::/64"},
+		{[]byte{0, 0}, "::/0"},
+		{[]byte{0, 129, 0x20, 0x01}, "Wrong prefix length 129"},
+		{[]byte{0}, "Wrong length 1"},
+	}
+	for _, test := range tests {
+		if got := parseIPv6Prefix(test.in); got != test.want {
+			t.Errorf("got %s, want %s", got, test.want)
+		}
+	}
+}
+
+func TestParseInterfaceId(t *testing.T) {
+	want := "211:22ff:fe33:4455"
+	got := parseInterfaceId([]byte{0x02, 0x11, 0x22, 0xff, 0xfe, 0x33, 0x44, 0x55})
+	if got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestParseDate(t *testing.T) {
+	want := "2023-01-02T03:04:05Z"
+	got := parseDate([]byte{0x63, 0xb2, 0x49, 0xa5})
+	if got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestParseInteger64(t *testing.T) {
+	want := "4294967306"
+	got := parseInteger64([]byte{0, 0, 0, 1, 0, 0, 0, 10})
+	if got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestParseShortAndByte(t *testing.T) {
+	if got := parseShort([]byte{1, 0}); got != "256" {
+		t.Errorf("got %s, want %s", got, "256")
+	}
+	if got := parseByte([]byte{7}); got != "7" {
+		t.Errorf("got %s, want %s", got, "7")
+	}
+}
+
+func TestEnumeratedValues(t *testing.T) {
+	tests := []struct {
+		attr RadiusAttribute
+		want JsonAttribute
+	}{
+		{RadiusAttribute{Type: 40, Value: []byte{0, 0, 0, 3}}, JsonAttribute{Name: "Acct-Status-Type", Value: "Interim-Update", Raw: "3"}},
+		{RadiusAttribute{Type: 49, Value: []byte{0, 0, 0, 1}}, JsonAttribute{Name: "Acct-Terminate-Cause", Value: "User-Request", Raw: "1"}},
+		{RadiusAttribute{Type: 61, Value: []byte{0, 0, 0, 15}}, JsonAttribute{Name: "NAS-Port-Type", Value: "Ethernet", Raw: "15"}},
+		{RadiusAttribute{Type: 6, Value: []byte{0, 0, 0, 2}}, JsonAttribute{Name: "Service-Type", Value: "Framed-User", Raw: "2"}},
+		{RadiusAttribute{Type: 45, Value: []byte{0, 0, 0, 1}}, JsonAttribute{Name: "Acct-Authentic", Value: "RADIUS", Raw: "1"}},
+		{RadiusAttribute{Type: 7, Value: []byte{0, 0, 0, 1}}, JsonAttribute{Name: "Framed-Protocol", Value: "PPP", Raw: "1"}},
+		{RadiusAttribute{Type: 40, Value: []byte{0, 0, 0, 99}}, JsonAttribute{Name: "Acct-Status-Type", Value: "99"}},
+		{RadiusAttribute{Type: 5, Value: []byte{0, 0, 0, 1}}, JsonAttribute{Name: "NAS-Port", Value: "1"}},
+	}
+	for _, test := range tests {
+		got := toJsonAttributes(test.attr)
+		if len(got) != 1 || got[0] != test.want {
+			t.Errorf("got %v, want %v", got, test.want)
+		}
+	}
+}
+
+func TestAccountingAttributes(t *testing.T) {
+	tests := []struct {
+		attr RadiusAttribute
+		want JsonAttribute
+	}{
+		{RadiusAttribute{Type: 55, Value: []byte{0x63, 0xb2, 0x49, 0xa5}}, JsonAttribute{Name: "Event-Timestamp", Value: "2023-01-02T03:04:05Z"}},
+		{RadiusAttribute{Type: 85, Value: []byte{0, 0, 1, 44}}, JsonAttribute{Name: "Acct-Interim-Interval", Value: "300"}},
+		{RadiusAttribute{Type: 87, Value: []byte("eth0/1")}, JsonAttribute{Name: "NAS-Port-Id", Value: "eth0/1"}},
+		{RadiusAttribute{Type: 88, Value: []byte("pool-a")}, JsonAttribute{Name: "Framed-Pool", Value: "pool-a"}},
+		{RadiusAttribute{Type: 89, Value: []byte("cui")}, JsonAttribute{Name: "Chargeable-User-Identity", Value: "cui"}},
+		{RadiusAttribute{Type: 77, Value: []byte("100000000")}, JsonAttribute{Name: "Connect-Info", Value: "100000000"}},
+		{RadiusAttribute{Type: 101, Value: []byte{0, 0, 1, 147}}, JsonAttribute{Name: "Error-Cause", Value: "NAS-Identification-Mismatch", Raw: "403"}},
+	}
+	for _, test := range tests {
+		got := toJsonAttributes(test.attr)
+		if len(got) != 1 || got[0] != test.want {
+			t.Errorf("got %v, want %v", got, test.want)
+		}
+	}
+}
+
+// TestAttributesMatchRegistry checks the names of Attributes against the IANA registry
+func TestAttributesMatchRegistry(t *testing.T) {
+	records, err := csv.NewReader(strings.NewReader(radiusTypes)).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := make(map[int]string)
+	for _, record := range records[1:] {
+		// ranges like 192-223 are not assignable
+		n, err := strconv.Atoi(record[0])
+		if err != nil {
+			continue
+		}
+		registry[n] = record[1]
+	}
+	// FreeRADIUS names that differ from the registry description
+	aliases := map[string]string{
+		"CUI":                   "Chargeable-User-Identity",
+		"Error-Cause Attribute": "Error-Cause",
+	}
+	for typ, attr := range Attributes {
+		name, ok := registry[typ]
+		if !ok || name == "Unassigned" {
+			t.Errorf("attribute %d %s is not assigned", typ, attr.Name)
+			continue
+		}
+		if alias, ok := aliases[name]; ok {
+			name = alias
+		}
+		if attr.Name != name {
+			t.Errorf("attribute %d is %s, registry says %s", typ, attr.Name, name)
+		}
+	}
+}
+
+func TestTaggedAttributes(t *testing.T) {
+	tests := []struct {
+		attr RadiusAttribute
+		want JsonAttribute
+	}{
+		{RadiusAttribute{Type: 64, Value: []byte{1, 0, 0, 3}}, JsonAttribute{Name: "Tunnel-Type", Value: "L2TP", Raw: "3", Tag: 1}},
+		{RadiusAttribute{Type: 65, Value: []byte{0, 0, 0, 1}}, JsonAttribute{Name: "Tunnel-Medium-Type", Value: "IPv4", Raw: "1"}},
+		{RadiusAttribute{Type: 67, Value: []byte{2, '1', '0', '.', '0', '.', '0', '.', '1'}}, JsonAttribute{Name: "Tunnel-Server-Endpoint", Value: "10.0.0.1", Tag: 2}},
+		{RadiusAttribute{Type: 66, Value: []byte("10.0.0.2")}, JsonAttribute{Name: "Tunnel-Client-Endpoint", Value: "10.0.0.2"}},
+		{RadiusAttribute{Type: 68, Value: []byte{1, '4', '2'}}, JsonAttribute{Name: "Acct-Tunnel-Connection", Value: "42", Tag: 1}},
+		{RadiusAttribute{Type: 82, Value: []byte{0x1f, 'l', 'n', 's'}}, JsonAttribute{Name: "Tunnel-Assignment-ID", Value: "lns", Tag: 0x1f}},
+		{RadiusAttribute{Type: 83, Value: []byte{3, 0, 1, 0}}, JsonAttribute{Name: "Tunnel-Preference", Value: "256", Tag: 3}},
+	}
+	for _, test := range tests {
+		got := toJsonAttributes(test.attr)
+		if len(got) != 1 || got[0] != test.want {
+			t.Errorf("got %v, want %v", got, test.want)
+		}
+	}
+}
+
+func TestTunnelGroups(t *testing.T) {
+	packet := &JsonPacket{}
+	for _, attr := range []RadiusAttribute{
+		{Type: 64, Value: []byte{1, 0, 0, 3}},
+		{Type: 64, Value: []byte{2, 0, 0, 3}},
+		{Type: 67, Value: []byte{1, 'a'}},
+		{Type: 67, Value: []byte{2, 'b'}},
+		{Type: 44, Value: []byte("session")},
+	} {
+		packet.Attributes = append(packet.Attributes, toJsonAttributes(attr)...)
+	}
+	groups := packet.TunnelGroups()
+	if len(groups) != 2 {
+		t.Fatalf("got %d groups, want 2", len(groups))
+	}
+	for tag, want := range map[uint8]string{1: "a", 2: "b"} {
+		if len(groups[tag]) != 2 || groups[tag][1].Value != want {
+			t.Errorf("group %d = %v, want Tunnel-Type and Tunnel-Server-Endpoint %s", tag, groups[tag], want)
+		}
+	}
+}
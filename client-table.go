@@ -0,0 +1,132 @@
+package accter
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// RadSecSecret is the shared secret used on RadSec connections (RFC 6614 section 2.3)
+const RadSecSecret = "radsec"
+
+// Client is a NAS which is allowed to send accounting requests to the server.
+type Client struct {
+	// Network is either a single IP address or a CIDR range like "10.0.0.0/8"
+	Network string
+	// CertificateName identifies RadSec clients by the common name or a DNS name of their certificate
+	CertificateName string
+	Name            string
+	Secret          string
+	// NasIdentifier is optional, if set the NAS-Identifier of every packet has to match
+	NasIdentifier string
+	// RequireMessageAuthenticator drops packets without a Message-Authenticator
+	RequireMessageAuthenticator bool
+	// SendMessageAuthenticator adds a Message-Authenticator to every response,
+	// responses to requests with a Message-Authenticator always contain one
+	SendMessageAuthenticator bool
+	ipNet                    *net.IPNet
+}
+
+type ClientTable struct {
+	mu      sync.RWMutex
+	clients []*Client
+}
+
+func NewClientTable() *ClientTable {
+	return &ClientTable{}
+}
+
+func (t *ClientTable) Add(c Client) error {
+	if c.Network == "" && c.CertificateName == "" {
+		return fmt.Errorf("client [%s] has neither a network nor a certificate name", c.Name)
+	}
+	if c.Network != "" {
+		if c.Secret == "" {
+			return fmt.Errorf("client [%s] has no secret", c.Name)
+		}
+		ipNet, err := parseClientNetwork(c.Network)
+		if err != nil {
+			return fmt.Errorf("client [%s] has an invalid network: %v", c.Name, err)
+		}
+		c.ipNet = ipNet
+	}
+	if c.Secret == "" {
+		c.Secret = RadSecSecret
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.clients = append(t.clients, &c)
+	return nil
+}
+
+/*
+ * Lookup returns the client with the most specific network containing ip
+ * or nil if the ip is not known.
+ */
+func (t *ClientTable) Lookup(ip net.IP) *Client {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	var match *Client
+	matchSize := -1
+	for _, c := range t.clients {
+		if c.ipNet == nil || !c.ipNet.Contains(ip) {
+			continue
+		}
+		if size, _ := c.ipNet.Mask.Size(); size > matchSize {
+			match = c
+			matchSize = size
+		}
+	}
+	return match
+}
+
+// LookupCertificate returns the client matching the common name or a DNS name of cert.
+func (t *ClientTable) LookupCertificate(cert *x509.Certificate) *Client {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	for _, c := range t.clients {
+		if c.CertificateName == "" {
+			continue
+		}
+		if c.CertificateName == cert.Subject.CommonName {
+			return c
+		}
+		for _, name := range cert.DNSNames {
+			if c.CertificateName == name {
+				return c
+			}
+		}
+	}
+	return nil
+}
+
+func parseClientNetwork(network string) (*net.IPNet, error) {
+	if strings.Contains(network, "/") {
+		_, ipNet, err := net.ParseCIDR(network)
+		return ipNet, err
+	}
+	ip := net.ParseIP(network)
+	if ip == nil {
+		return nil, fmt.Errorf("%q is not an ip address", network)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP
+	case *net.TCPAddr:
+		return a.IP
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
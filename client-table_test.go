@@ -0,0 +1,46 @@
+package accter
+
+import (
+	"net"
+	"testing"
+)
+
+func TestClientTableLookup(t *testing.T) {
+	table := NewClientTable()
+	clients := []Client{
+		{Network: "10.0.0.0/8", Name: "site", Secret: "a"},
+		{Network: "10.1.0.0/16", Name: "bng", Secret: "b"},
+		{Network: "10.1.2.3", Name: "nas", Secret: "c"},
+		{Network: "2001:db8::/32", Name: "v6", Secret: "d"},
+	}
+	for _, c := range clients {
+		if err := table.Add(c); err != nil {
+			t.Fatalf("Add(%s) failed with: %v", c.Name, err)
+		}
+	}
+	tests := map[string]string{
+		"10.9.9.9":    "site",
+		"10.1.9.9":    "bng",
+		"10.1.2.3":    "nas",
+		"2001:db8::1": "v6",
+	}
+	for ip, want := range tests {
+		got := table.Lookup(net.ParseIP(ip))
+		if got == nil || got.Name != want {
+			t.Errorf("Lookup(%s) = %v, want %s", ip, got, want)
+		}
+	}
+	if got := table.Lookup(net.ParseIP("192.168.1.1")); got != nil {
+		t.Errorf("Lookup(192.168.1.1) = %s, want nil", got.Name)
+	}
+}
+
+func TestClientTableAddFail(t *testing.T) {
+	table := NewClientTable()
+	if err := table.Add(Client{Network: "10.0.0.300", Name: "bad", Secret: "a"}); err == nil {
+		t.Errorf("invalid network accepted")
+	}
+	if err := table.Add(Client{Network: "10.0.0.1", Name: "nosecret"}); err == nil {
+		t.Errorf("client without secret accepted")
+	}
+}
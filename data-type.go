@@ -0,0 +1,298 @@
+package accter
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net"
+	"net/netip"
+	"reflect"
+	"time"
+)
+
+// DataType is the type of an attribute value as used in FreeRADIUS dictionaries.
+type DataType int
+
+const (
+	TypeString DataType = iota
+	TypeOctets
+	TypeInteger
+	TypeIPAddr
+	TypeIPv6Addr
+	TypeIPv6Prefix
+	TypeIfId
+	TypeDate
+	TypeInteger64
+	TypeByte
+	TypeShort
+)
+
+var dataTypeNames = map[DataType]string{
+	TypeString:     "string",
+	TypeOctets:     "octets",
+	TypeInteger:    "integer",
+	TypeIPAddr:     "ipaddr",
+	TypeIPv6Addr:   "ipv6addr",
+	TypeIPv6Prefix: "ipv6prefix",
+	TypeIfId:       "ifid",
+	TypeDate:       "date",
+	TypeInteger64:  "integer64",
+	TypeByte:       "byte",
+	TypeShort:      "short",
+}
+
+func (t DataType) String() string {
+	if name, ok := dataTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("DataType(%d)", int(t))
+}
+
+// ParseDataType returns the data type for a FreeRADIUS type name like "integer"
+func ParseDataType(name string) (DataType, error) {
+	for t, n := range dataTypeNames {
+		if n == name {
+			return t, nil
+		}
+	}
+	return TypeOctets, fmt.Errorf("unknown data type %q", name)
+}
+
+func (t DataType) parser() func([]byte) string {
+	switch t {
+	case TypeString:
+		return parseString
+	case TypeInteger:
+		return parseInteger
+	case TypeIPAddr:
+		return parseIPAddr
+	case TypeIPv6Addr:
+		return parseIPv6Addr
+	case TypeIPv6Prefix:
+		return parseIPv6Prefix
+	case TypeIfId:
+		return parseInterfaceId
+	case TypeDate:
+		return parseDate
+	case TypeInteger64:
+		return parseInteger64
+	case TypeByte:
+		return parseByte
+	case TypeShort:
+		return parseShort
+	default:
+		return parseOctets
+	}
+}
+
+// uint returns the number of integer types, false if t is no integer type or b has the wrong length
+func (t DataType) uint(b []byte) (uint64, bool) {
+	switch {
+	case t == TypeInteger && len(b) == 4:
+		return uint64(binary.BigEndian.Uint32(b)), true
+	case t == TypeInteger64 && len(b) == 8:
+		return binary.BigEndian.Uint64(b), true
+	case t == TypeByte && len(b) == 1:
+		return uint64(b[0]), true
+	case t == TypeShort && len(b) == 2:
+		return uint64(binary.BigEndian.Uint16(b)), true
+	}
+	return 0, false
+}
+
+/*
+ * decode returns the typed value of b:
+ *  - integer, byte and short as uint32, integer64 as uint64
+ *  - ipaddr and ipv6addr as net.IP, ipv6prefix as netip.Prefix
+ *  - date as time.Time, octets as []byte
+ *  - string and ifid as string
+ */
+func (t DataType) decode(b []byte) (interface{}, error) {
+	switch t {
+	case TypeString:
+		return string(b), nil
+	case TypeInteger, TypeByte, TypeShort:
+		n, ok := t.uint(b)
+		if !ok {
+			return nil, fmt.Errorf("wrong length %d for %s", len(b), t)
+		}
+		return uint32(n), nil
+	case TypeInteger64:
+		n, ok := t.uint(b)
+		if !ok {
+			return nil, fmt.Errorf("wrong length %d for %s", len(b), t)
+		}
+		return n, nil
+	case TypeIPAddr:
+		if len(b) != net.IPv4len {
+			return nil, fmt.Errorf("wrong length %d for %s", len(b), t)
+		}
+		return net.IPv4(b[0], b[1], b[2], b[3]).To4(), nil
+	case TypeIPv6Addr:
+		if len(b) != net.IPv6len {
+			return nil, fmt.Errorf("wrong length %d for %s", len(b), t)
+		}
+		return append(net.IP(nil), b...), nil
+	case TypeIPv6Prefix:
+		if len(b) < 2 || len(b) > 2+net.IPv6len {
+			return nil, fmt.Errorf("wrong length %d for %s", len(b), t)
+		}
+		if b[1] > 128 {
+			return nil, fmt.Errorf("wrong prefix length %d", b[1])
+		}
+		var addr [16]byte
+		copy(addr[:], b[2:])
+		return netip.PrefixFrom(netip.AddrFrom16(addr), int(b[1])).Masked(), nil
+	case TypeIfId:
+		if len(b) != 8 {
+			return nil, fmt.Errorf("wrong length %d for %s", len(b), t)
+		}
+		return parseInterfaceId(b), nil
+	case TypeDate:
+		if len(b) != 4 {
+			return nil, fmt.Errorf("wrong length %d for %s", len(b), t)
+		}
+		return time.Unix(int64(binary.BigEndian.Uint32(b)), 0).UTC(), nil
+	default:
+		return append([]byte(nil), b...), nil
+	}
+}
+
+/*
+ * encode returns the attribute value of v and accepts the types returned by
+ * decode. Integer types also accept the other Go integer types, ipaddr and
+ * ipv6addr also accept netip.Addr.
+ */
+func (t DataType) encode(v interface{}) ([]byte, error) {
+	switch t {
+	case TypeString, TypeOctets:
+		switch v := v.(type) {
+		case string:
+			return []byte(v), nil
+		case []byte:
+			return v, nil
+		}
+	case TypeInteger, TypeDate, TypeByte, TypeShort, TypeInteger64:
+		if d, ok := v.(time.Time); ok && t == TypeDate {
+			v = d.Unix()
+		}
+		n, ok := toUint64(v)
+		if !ok {
+			break
+		}
+		return t.encodeUint(n)
+	case TypeIPAddr, TypeIPv6Addr:
+		var addr netip.Addr
+		switch v := v.(type) {
+		case net.IP:
+			addr, _ = netip.AddrFromSlice(v)
+		case netip.Addr:
+			addr = v
+		}
+		if t == TypeIPAddr && addr.Unmap().Is4() {
+			b := addr.Unmap().As4()
+			return b[:], nil
+		}
+		if t == TypeIPv6Addr && addr.Is6() {
+			b := addr.As16()
+			return b[:], nil
+		}
+	case TypeIPv6Prefix:
+		if prefix, ok := v.(netip.Prefix); ok && prefix.Addr().Is6() {
+			addr := prefix.Masked().Addr().As16()
+			return append([]byte{0, byte(prefix.Bits())}, addr[:(prefix.Bits()+7)/8]...), nil
+		}
+	case TypeIfId:
+		if s, ok := v.(string); ok {
+			var ifid [4]uint16
+			if n, err := fmt.Sscanf(s, "%x:%x:%x:%x", &ifid[0], &ifid[1], &ifid[2], &ifid[3]); err == nil && n == 4 {
+				b := make([]byte, 8)
+				for i, part := range ifid {
+					binary.BigEndian.PutUint16(b[2*i:], part)
+				}
+				return b, nil
+			}
+			return nil, fmt.Errorf("invalid interface id %q", s)
+		}
+		if b, ok := v.([]byte); ok && len(b) == 8 {
+			return b, nil
+		}
+	}
+	return nil, fmt.Errorf("can not encode %T as %s", v, t)
+}
+
+// encodeUint returns n in the size of the integer type t
+func (t DataType) encodeUint(n uint64) ([]byte, error) {
+	var b []byte
+	switch t {
+	case TypeInteger, TypeDate:
+		if n > math.MaxUint32 {
+			break
+		}
+		b = binary.BigEndian.AppendUint32(nil, uint32(n))
+	case TypeInteger64:
+		b = binary.BigEndian.AppendUint64(nil, n)
+	case TypeShort:
+		if n > math.MaxUint16 {
+			break
+		}
+		b = binary.BigEndian.AppendUint16(nil, uint16(n))
+	case TypeByte:
+		if n > math.MaxUint8 {
+			break
+		}
+		b = []byte{byte(n)}
+	}
+	if b == nil {
+		return nil, fmt.Errorf("value %d out of range for %s", n, t)
+	}
+	return b, nil
+}
+
+// toUint64 converts the Go integer types, negative numbers are no valid attribute values
+func toUint64(v interface{}) (uint64, bool) {
+	switch v := v.(type) {
+	case uint8:
+		return uint64(v), true
+	case uint16:
+		return uint64(v), true
+	case uint32:
+		return uint64(v), true
+	case uint64:
+		return v, true
+	case uint:
+		return uint64(v), true
+	case int8, int16, int32, int64, int:
+		n := reflect.ValueOf(v).Int()
+		return uint64(n), n >= 0
+	}
+	return 0, false
+}
+
+func parseDate(b []byte) string {
+	if len(b) != 4 {
+		return fmt.Sprintf("Wrong length %d", len(b))
+	}
+	return time.Unix(int64(binary.BigEndian.Uint32(b)), 0).UTC().Format(time.RFC3339)
+}
+
+func parseInteger64(b []byte) string {
+	if len(b) != 8 {
+		return fmt.Sprintf("Wrong length %d", len(b))
+	}
+	return fmt.Sprintf("%d", binary.BigEndian.Uint64(b))
+}
+
+func parseByte(b []byte) string {
+	if len(b) != 1 {
+		return fmt.Sprintf("Wrong length %d", len(b))
+	}
+	return fmt.Sprintf("%d", b[0])
+}
+
+func parseShort(b []byte) string {
+	if len(b) != 2 {
+		return fmt.Sprintf("Wrong length %d", len(b))
+	}
+	return fmt.Sprintf("%d", binary.BigEndian.Uint16(b))
+}
@@ -0,0 +1,374 @@
+package accter
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// maxIncludeDepth protects against $INCLUDE loops
+const maxIncludeDepth = 16
+
+type dictionaryAttribute struct {
+	vendorId uint32
+	typ      int
+	// extended is the dotted number of extended attributes like "241.1"
+	extended string
+}
+
+type dictionary struct {
+	// names holds all known attributes by name, VALUE lines refer to them
+	names map[string]dictionaryAttribute
+	// skipped holds the names of vendor TLVs, their VALUE lines are skipped as well
+	skipped map[string]bool
+	vendor  uint32
+	// extendedVendor is the type of the Extended-Vendor-Specific attribute of the vendor block, 0 for Vendor-Specific
+	extendedVendor uint8
+}
+
+/*
+ * LoadDictionary reads a dictionary file in FreeRADIUS syntax and registers
+ * its vendors, attributes and values in Vendors and Attributes. It supports:
+ *  - ATTRIBUTE <name> <number> <type> [vendor]
+ *  - ATTRIBUTE <name> <dotted number> <type> for extended attributes like 241.1
+ *  - the has_tag and encrypt=2 flags for tagged and salt encrypted attributes,
+ *    other flags are ignored
+ *  - VALUE <attribute> <name> <number>
+ *  - VENDOR <name> <id> [format=<type size>,<length size>[,c]] with type sizes
+ *    1, 2 or 4, length sizes 0, 1 or 2 and c for the WiMAX continuation byte
+ *  - BEGIN-VENDOR <name> [format=Extended-Vendor-Specific-<1-6>] and
+ *    END-VENDOR <name>, the attributes of an Extended-Vendor-Specific block
+ *    are registered as extended attributes like 241.26.<vendor>.<type>,
+ *    dotted numbers of vendor TLVs like 1.1 are skipped, their parent is
+ *    registered as octets
+ *  - $INCLUDE <path> relative to the including file
+ * Numbers are decimal unless they start with 0x. Unknown data types are
+ * registered as octets. Dictionaries should be loaded
+ * before the server is started.
+ */
+func LoadDictionary(path string) error {
+	d := &dictionary{names: make(map[string]dictionaryAttribute), skipped: make(map[string]bool)}
+	for typ, attr := range Attributes {
+		d.names[attr.Name] = dictionaryAttribute{typ: typ}
+	}
+	for vendorId, vendor := range Vendors {
+		for typ, attr := range vendor.Attributes {
+			d.names[attr.Name] = dictionaryAttribute{vendorId: vendorId, typ: typ}
+		}
+	}
+	for number, attr := range ExtendedAttributes {
+		d.names[attr.Name] = dictionaryAttribute{extended: number}
+	}
+	return d.load(path, 0)
+}
+
+func (d *dictionary) load(path string, depth int) error {
+	if depth > maxIncludeDepth {
+		return fmt.Errorf("%s: too many nested includes", path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		if i := strings.Index(text, "#"); i >= 0 {
+			text = text[:i]
+		}
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "$INCLUDE", "$INCLUDE-":
+			if len(fields) != 2 {
+				err = fmt.Errorf("invalid $INCLUDE")
+				break
+			}
+			include := fields[1]
+			if !filepath.IsAbs(include) {
+				include = filepath.Join(filepath.Dir(path), include)
+			}
+			err = d.load(include, depth+1)
+			if err != nil && fields[0] == "$INCLUDE-" && os.IsNotExist(err) {
+				err = nil
+			}
+		case "ATTRIBUTE":
+			err = d.parseAttribute(fields[1:])
+		case "VALUE":
+			err = d.parseValue(fields[1:])
+		case "VENDOR":
+			err = d.parseVendor(fields[1:])
+		case "BEGIN-VENDOR":
+			err = d.beginVendor(fields[1:])
+		case "END-VENDOR":
+			d.vendor, d.extendedVendor = 0, 0
+		default:
+			err = fmt.Errorf("unknown keyword %q", fields[0])
+		}
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+	}
+	return scanner.Err()
+}
+
+func (d *dictionary) parseAttribute(fields []string) error {
+	if len(fields) < 3 {
+		return fmt.Errorf("invalid ATTRIBUTE")
+	}
+	name := fields[0]
+	helper := AttributeHelper{Name: name, Type: parseDictionaryType(fields[2])}
+	vendorId := d.vendor
+	// old style dictionaries name the vendor after the type, else it holds flags
+	if len(fields) > 3 {
+		if id, err := d.vendorId(fields[3]); err == nil {
+			vendorId = id
+		} else {
+			helper.Tagged = hasDictionaryFlag(fields[3], "has_tag")
+			helper.Encrypted = hasDictionaryFlag(fields[3], "encrypt=2")
+		}
+	}
+	if strings.Contains(fields[1], ".") {
+		if vendorId != 0 {
+			// vendor TLVs are not supported, the parent attribute holds their raw value
+			d.skipped[name] = true
+			return nil
+		}
+		return d.parseExtendedAttribute(name, fields[1], helper)
+	}
+	if d.extendedVendor != 0 && vendorId == d.vendor {
+		number := fmt.Sprintf("%d.%d.%d.%s", d.extendedVendor, extendedVendorSpecific, vendorId, fields[1])
+		return d.parseExtendedAttribute(name, number, helper)
+	}
+	typ, err := parseDictionaryNumber(fields[1])
+	if err != nil {
+		return err
+	}
+	if vendorId == 0 {
+		if typ > 255 {
+			return fmt.Errorf("attribute number %d out of range", typ)
+		}
+		if old, ok := Attributes[typ]; ok && old.Name == name {
+			helper.Values = old.Values
+		}
+		Attributes[typ] = helper
+	} else {
+		vendor := Vendors[vendorId]
+		if vendor.Attributes == nil {
+			vendor.Attributes = make(map[int]AttributeHelper)
+		}
+		if old, ok := vendor.Attributes[typ]; ok && old.Name == name {
+			helper.Values = old.Values
+		}
+		vendor.Attributes[typ] = helper
+		Vendors[vendorId] = vendor
+	}
+	d.names[name] = dictionaryAttribute{vendorId: vendorId, typ: typ}
+	return nil
+}
+
+// parseExtendedAttribute registers an attribute of the RFC 6929 extended attribute space
+func (d *dictionary) parseExtendedAttribute(name, number string, helper AttributeHelper) error {
+	parts := strings.Split(number, ".")
+	if len(parts) != 2 && len(parts) != 4 {
+		return fmt.Errorf("invalid extended attribute number %q", number)
+	}
+	for i, part := range parts {
+		n, err := parseDictionaryNumber(part)
+		if err != nil {
+			return err
+		}
+		if i == 0 && (n < int(typeExtended1) || n > int(typeLongExtended2)) {
+			return fmt.Errorf("attribute number %d is not extended", n)
+		}
+		if i != 2 && n > 255 {
+			return fmt.Errorf("attribute number %d out of range", n)
+		}
+		parts[i] = strconv.Itoa(n)
+	}
+	if len(parts) == 4 && parts[1] != strconv.Itoa(int(extendedVendorSpecific)) {
+		return fmt.Errorf("invalid extended attribute number %q", number)
+	}
+	number = strings.Join(parts, ".")
+	if old, ok := ExtendedAttributes[number]; ok && old.Name == name {
+		helper.Values = old.Values
+	}
+	ExtendedAttributes[number] = helper
+	d.names[name] = dictionaryAttribute{extended: number}
+	return nil
+}
+
+func (d *dictionary) parseValue(fields []string) error {
+	if len(fields) != 3 {
+		return fmt.Errorf("invalid VALUE")
+	}
+	if d.skipped[fields[0]] {
+		return nil
+	}
+	attr, ok := d.names[fields[0]]
+	if !ok {
+		return fmt.Errorf("VALUE for unknown attribute %q", fields[0])
+	}
+	n, err := parseDictionaryUint(fields[2])
+	if err != nil {
+		return fmt.Errorf("invalid value %q", fields[2])
+	}
+	var helper AttributeHelper
+	if attr.extended != "" {
+		helper = ExtendedAttributes[attr.extended]
+	} else if attr.vendorId == 0 {
+		helper = Attributes[attr.typ]
+	} else {
+		helper = Vendors[attr.vendorId].Attributes[attr.typ]
+	}
+	values := make(map[uint64]string, len(helper.Values)+1)
+	for k, v := range helper.Values {
+		values[k] = v
+	}
+	values[n] = fields[1]
+	helper.Values = values
+	if attr.extended != "" {
+		ExtendedAttributes[attr.extended] = helper
+	} else if attr.vendorId == 0 {
+		Attributes[attr.typ] = helper
+	} else {
+		Vendors[attr.vendorId].Attributes[attr.typ] = helper
+	}
+	return nil
+}
+
+func (d *dictionary) parseVendor(fields []string) error {
+	if len(fields) < 2 {
+		return fmt.Errorf("invalid VENDOR")
+	}
+	id, err := parseDictionaryNumber(fields[1])
+	if err != nil {
+		return err
+	}
+	vendor := Vendors[uint32(id)]
+	vendor.Name = fields[0]
+	vendor.TypeSize, vendor.LengthSize, vendor.NoLength, vendor.Continuation = 0, 0, false, false
+	if len(fields) > 2 {
+		if err := parseVendorFormat(&vendor, fields[2]); err != nil {
+			return err
+		}
+	}
+	Vendors[uint32(id)] = vendor
+	return nil
+}
+
+// parseVendorFormat sets the sizes of a format like "format=2,2" or "format=1,1,c" on the vendor
+func parseVendorFormat(vendor *VendorHelper, format string) error {
+	if !strings.HasPrefix(format, "format=") {
+		return fmt.Errorf("invalid vendor format %q", format)
+	}
+	parts := strings.Split(strings.TrimPrefix(format, "format="), ",")
+	if len(parts) < 2 || len(parts) > 3 {
+		return fmt.Errorf("invalid vendor format %q", format)
+	}
+	switch parts[0] {
+	case "1", "2", "4":
+		vendor.TypeSize, _ = strconv.Atoi(parts[0])
+	default:
+		return fmt.Errorf("invalid vendor type size %q", parts[0])
+	}
+	switch parts[1] {
+	case "0":
+		vendor.NoLength = true
+	case "1", "2":
+		vendor.LengthSize, _ = strconv.Atoi(parts[1])
+	default:
+		return fmt.Errorf("invalid vendor length size %q", parts[1])
+	}
+	if len(parts) == 3 {
+		if parts[2] != "c" {
+			return fmt.Errorf("invalid vendor format %q", format)
+		}
+		if vendor.TypeSize != 1 || vendor.LengthSize != 1 {
+			return fmt.Errorf("vendor format %q: continuation requires format=1,1,c", format)
+		}
+		vendor.Continuation = true
+	}
+	return nil
+}
+
+func (d *dictionary) beginVendor(fields []string) error {
+	if len(fields) < 1 {
+		return fmt.Errorf("invalid BEGIN-VENDOR")
+	}
+	id, err := d.vendorId(fields[0])
+	if err != nil {
+		return err
+	}
+	d.vendor, d.extendedVendor = id, 0
+	if len(fields) > 1 {
+		if d.extendedVendor, err = parseExtendedVendorFormat(fields[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseExtendedVendorFormat returns the attribute type of a format like "format=Extended-Vendor-Specific-5"
+func parseExtendedVendorFormat(format string) (uint8, error) {
+	n, err := strconv.Atoi(strings.TrimPrefix(format, "format=Extended-Vendor-Specific-"))
+	if err != nil || !strings.HasPrefix(format, "format=Extended-Vendor-Specific-") || n < 1 || n > 6 {
+		return 0, fmt.Errorf("unsupported BEGIN-VENDOR format %q", format)
+	}
+	return typeExtended1 + uint8(n-1), nil
+}
+
+func (d *dictionary) vendorId(name string) (uint32, error) {
+	for id, vendor := range Vendors {
+		if vendor.Name == name {
+			return id, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown vendor %q", name)
+}
+
+func parseDictionaryNumber(s string) (int, error) {
+	n, err := parseDictionaryUint(s)
+	if err != nil || n > 1<<32-1 {
+		return 0, fmt.Errorf("invalid number %q", s)
+	}
+	return int(n), nil
+}
+
+// parseDictionaryUint parses decimal numbers, leading zeros like "08" included, and hex numbers like "0x1a"
+func parseDictionaryUint(s string) (uint64, error) {
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+		return strconv.ParseUint(s[2:], 16, 64)
+	}
+	return strconv.ParseUint(s, 10, 64)
+}
+
+// hasDictionaryFlag reports whether the comma separated flags like "has_tag,encrypt=2" contain flag
+func hasDictionaryFlag(flags, flag string) bool {
+	for _, f := range strings.Split(flags, ",") {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// parseDictionaryType maps a type like "octets[16]" to its data type
+func parseDictionaryType(s string) DataType {
+	if i := strings.Index(s, "["); i >= 0 {
+		s = s[:i]
+	}
+	t, err := ParseDataType(s)
+	if err != nil {
+		return TypeOctets
+	}
+	return t
+}
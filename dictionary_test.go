@@ -0,0 +1,166 @@
+package accter
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const (
+	testDictionary = `# test dictionary
+ATTRIBUTE	Test-Counter		230	integer64
+ATTRIBUTE	Test-Status		231	integer
+ATTRIBUTE	Test-Tunnel		232	string	has_tag
+VALUE	Test-Status		Up			1
+VALUE	Test-Status		Down			0x2
+VALUE	Test-Status		Testing			08
+ATTRIBUTE	Test-Extended		241.230	integer
+VALUE	Test-Extended		On			1
+ATTRIBUTE	Acme-Extended		241.26.64999.1	string
+$INCLUDE vendor/dictionary.acme
+`
+	testVendorDictionary = `VENDOR		Acme				64999	format=2,2
+BEGIN-VENDOR	Acme
+ATTRIBUTE	Acme-Prefix		1	ipv6prefix
+ATTRIBUTE	Acme-Mode		2	short
+ATTRIBUTE	Acme-Key		3	octets[16]	encrypt=2
+VALUE	Acme-Mode		Fast			7
+END-VENDOR	Acme
+ATTRIBUTE	Acme-Label		4	string	Acme
+BEGIN-VENDOR	Acme	format=Extended-Vendor-Specific-5
+ATTRIBUTE	Acme-Long		5	string
+VALUE	Acme-Mode		Slow			8
+END-VENDOR	Acme
+`
+)
+
+func WriteTestDictionary(t *testing.T) string {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "vendor"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "dictionary"), []byte(testDictionary), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "vendor", "dictionary.acme"), []byte(testVendorDictionary), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		delete(Attributes, 230)
+		delete(Attributes, 231)
+		delete(Attributes, 232)
+		delete(Vendors, 64999)
+		delete(ExtendedAttributes, "241.230")
+		delete(ExtendedAttributes, "241.26.64999.1")
+		delete(ExtendedAttributes, "245.26.64999.5")
+	})
+	return filepath.Join(dir, "dictionary")
+}
+
+func TestLoadDictionary(t *testing.T) {
+	if err := LoadDictionary(WriteTestDictionary(t)); err != nil {
+		t.Fatalf("LoadDictionary failed with: %v", err)
+	}
+	tests := []struct {
+		attr RadiusAttribute
+		want JsonAttribute
+	}{
+		{RadiusAttribute{Type: 230, Value: []byte{0, 0, 0, 1, 0, 0, 0, 0}}, JsonAttribute{Name: "Test-Counter", Value: "4294967296"}},
+		{RadiusAttribute{Type: 231, Value: []byte{0, 0, 0, 2}}, JsonAttribute{Name: "Test-Status", Value: "Down", Raw: "2"}},
+		{RadiusAttribute{Type: 231, Value: []byte{0, 0, 0, 3}}, JsonAttribute{Name: "Test-Status", Value: "3"}},
+		{RadiusAttribute{Type: 231, Value: []byte{0, 0, 0, 8}}, JsonAttribute{Name: "Test-Status", Value: "Testing", Raw: "8"}},
+		{RadiusAttribute{Type: 232, Value: []byte{2, 'l', 'n', 's'}}, JsonAttribute{Name: "Test-Tunnel", Value: "lns", Tag: 2}},
+		{RadiusAttribute{Type: 26, Value: []byte{0, 0, 0xfd, 0xe7, 0, 1, 0, 8, 0, 48, 0x20, 0x01}}, JsonAttribute{Name: "Acme-Prefix", Value: "2001::/48"}},
+		{RadiusAttribute{Type: 26, Value: []byte{0, 0, 0xfd, 0xe7, 0, 2, 0, 6, 0, 7}}, JsonAttribute{Name: "Acme-Mode", Value: "Fast", Raw: "7"}},
+		{RadiusAttribute{Type: 26, Value: []byte{0, 0, 0xfd, 0xe7, 0, 3, 0, 5, 0xff}}, JsonAttribute{Name: "Acme-Key", Value: "ff"}},
+		{RadiusAttribute{Type: 26, Value: []byte{0, 0, 0xfd, 0xe7, 0, 4, 0, 6, 'o', 'k'}}, JsonAttribute{Name: "Acme-Label", Value: "ok"}},
+		{RadiusAttribute{Type: 241, Value: []byte{230, 0, 0, 0, 1}}, JsonAttribute{Name: "Test-Extended", Value: "On", Raw: "1"}},
+		{RadiusAttribute{Type: 241, Value: []byte{26, 0, 0, 0xfd, 0xe7, 1, 'o', 'k'}}, JsonAttribute{Name: "Acme-Extended", Value: "ok"}},
+		{RadiusAttribute{Type: 245, Value: []byte{26, 0, 0, 0, 0xfd, 0xe7, 5, 'o', 'k'}}, JsonAttribute{Name: "Acme-Long", Value: "ok"}},
+		{RadiusAttribute{Type: 26, Value: []byte{0, 0, 0xfd, 0xe7, 0, 2, 0, 6, 0, 8}}, JsonAttribute{Name: "Acme-Mode", Value: "Slow", Raw: "8"}},
+	}
+	for _, test := range tests {
+		got := toJsonAttributes(test.attr)
+		if len(got) != 1 || got[0] != test.want {
+			t.Errorf("got %v, want %v", got, test.want)
+		}
+	}
+}
+
+func TestLoadDictionaryFail(t *testing.T) {
+	dir := t.TempDir()
+	tests := []string{
+		"ATTRIBUTE Broken",
+		"ATTRIBUTE Broken 26.1 integer",
+		"ATTRIBUTE Broken 241.1.1 integer",
+		"VENDOR Broken 64998 format=3,1",
+		"VENDOR Broken 64998 format=1,3",
+		"VENDOR Broken 64998 format=1",
+		"VENDOR Broken 64998 format=1,1,x",
+		"VENDOR Broken 64998 format=2,2,c",
+		"VENDOR Broken 64998 format=1,1,c,c",
+		"VALUE Acct-Status-Type Broken 09x",
+		"VALUE Unknown-Attribute Up 1",
+		"BEGIN-VENDOR Unknown-Vendor",
+		"BEGIN-VENDOR Cisco format=Extended-Vendor-Specific-7",
+		"BEGIN-VENDOR Cisco format=2,2",
+		"$INCLUDE missing",
+		"FOO bar",
+	}
+	for _, test := range tests {
+		path := filepath.Join(dir, "dictionary")
+		if err := os.WriteFile(path, []byte(test), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := LoadDictionary(path); err == nil {
+			t.Errorf("LoadDictionary(%q) did not fail", test)
+		}
+	}
+}
+
+func TestLoadVendorDictionary(t *testing.T) {
+	t.Cleanup(func() {
+		delete(Vendors, 24757)
+	})
+	if err := LoadDictionary(filepath.Join("testdata", "dictionary.wimax")); err != nil {
+		t.Fatalf("LoadDictionary failed with: %v", err)
+	}
+	if vendor := Vendors[24757]; !vendor.Continuation || vendor.TypeSize != 1 || vendor.LengthSize != 1 {
+		t.Errorf("vendor = %+v, want format=1,1,c", vendor)
+	}
+	tests := []struct {
+		attr RadiusAttribute
+		want JsonAttribute
+	}{
+		{RadiusAttribute{Type: 26, Value: []byte{0, 0, 0x60, 0xb5, 2, 4, 0, 1}}, JsonAttribute{Name: "WiMAX-Device-Authentication-Indicator", Value: "Authentication-Succeeded", Raw: "1"}},
+		{RadiusAttribute{Type: 26, Value: []byte{0, 0, 0x60, 0xb5, 4, 5, 0, 0xab, 0xcd}}, JsonAttribute{Name: "WiMAX-AAA-Session-Id", Value: "abcd"}},
+		// the TLVs of WiMAX-Capability are not resolved
+		{RadiusAttribute{Type: 26, Value: []byte{0, 0, 0x60, 0xb5, 1, 6, 0, 1, 3, '1'}}, JsonAttribute{Name: "WiMAX-Capability", Value: "010331"}},
+	}
+	for _, test := range tests {
+		got := toJsonAttributes(test.attr)
+		if len(got) != 1 || got[0] != test.want {
+			t.Errorf("got %v, want %v", got, test.want)
+		}
+	}
+	attr, err := NewAttribute("WiMAX-Device-Authentication-Indicator", "Authentication-Failed")
+	if err != nil {
+		t.Fatalf("NewAttribute failed with: %v", err)
+	}
+	if want := []byte{0, 0, 0x60, 0xb5, 2, 4, 0, 2}; !bytes.Equal(attr.Value, want) {
+		t.Errorf("NewAttribute() = %x, want %x", attr.Value, want)
+	}
+}
+
+func TestParseDataType(t *testing.T) {
+	for typ, name := range dataTypeNames {
+		got, err := ParseDataType(name)
+		if err != nil || got != typ {
+			t.Errorf("ParseDataType(%s) = %v, want %v", name, got, typ)
+		}
+	}
+	if _, err := ParseDataType("tlv"); err == nil {
+		t.Errorf("ParseDataType(tlv) did not fail")
+	}
+}
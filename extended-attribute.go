@@ -0,0 +1,177 @@
+package accter
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Attribute types of the extended attribute space described in RFC 6929.
+const (
+	typeExtended1     uint8 = 241
+	typeExtended4     uint8 = 244
+	typeLongExtended1 uint8 = 245
+	typeLongExtended2 uint8 = 246
+
+	// extendedVendorSpecific is the Extended-Type of Extended-Vendor-Specific attributes
+	extendedVendorSpecific uint8 = 26
+	// flagMore marks a Long-Extended-Type fragment which is continued in the next attribute
+	flagMore uint8 = 0x80
+)
+
+/*
+ * ExtendedAttributes are the attributes of the extended attribute space
+ * by their dotted number like "241.1". Extended-Vendor-Specific attributes
+ * are named by type, extended type, vendor id and vendor type like "241.26.9.1".
+ */
+var ExtendedAttributes = map[string]AttributeHelper{
+	"241.1": {Name: "Frag-Status", Type: TypeInteger},
+	"241.2": {Name: "Proxy-State-Length", Type: TypeInteger},
+	"241.3": {Name: "Response-Length", Type: TypeInteger},
+	"241.4": {Name: "Original-Packet-Code", Type: TypeInteger},
+	"245.1": {Name: "SAML-Assertion", Type: TypeString},
+	"245.2": {Name: "SAML-Protocol", Type: TypeString},
+}
+
+type ExtendedAttribute struct {
+	Type         uint8
+	ExtendedType uint8
+	// VendorId and VendorType are set for Extended-Vendor-Specific attributes
+	VendorId   uint32
+	VendorType uint8
+	Value      []byte
+}
+
+// Number returns the dotted number of the attribute like "241.1" or "241.26.9.1"
+func (a ExtendedAttribute) Number() string {
+	if a.ExtendedType == extendedVendorSpecific {
+		return fmt.Sprintf("%d.%d.%d.%d", a.Type, a.ExtendedType, a.VendorId, a.VendorType)
+	}
+	return fmt.Sprintf("%d.%d", a.Type, a.ExtendedType)
+}
+
+func isExtended(t uint8) bool {
+	return t >= typeExtended1 && t <= typeExtended4
+}
+
+func isLongExtended(t uint8) bool {
+	return t == typeLongExtended1 || t == typeLongExtended2
+}
+
+/*
+ * ParseExtendedAttributes decodes the extended attributes of attrs as
+ * described in RFC 6929 section 2. While for Extended-Type attributes:
+ *  - b[0] is the extended type
+ *  - b[1:rest] is the value
+ * and for Long-Extended-Type attributes:
+ *  - b[0] is the extended type
+ *  - b[1] are the flags, the "More" flag continues the value in the next attribute
+ *  - b[2:rest] is the value
+ * Fragments are reassembled. Extended-Vendor-Specific values start with the
+ * four bytes vendor id and the vendor type. Other attributes are skipped.
+ */
+func ParseExtendedAttributes(attrs []RadiusAttribute) ([]ExtendedAttribute, error) {
+	extended := make([]ExtendedAttribute, 0)
+	for i := 0; i < len(attrs); i++ {
+		if !isExtended(attrs[i].Type) && !isLongExtended(attrs[i].Type) {
+			continue
+		}
+		attr, n, err := parseExtendedAttribute(attrs[i:])
+		if err != nil {
+			return nil, err
+		}
+		extended = append(extended, attr)
+		i += n - 1
+	}
+	return extended, nil
+}
+
+// parseExtendedAttribute decodes the extended attribute at attrs[0] and returns the number of attributes used
+func parseExtendedAttribute(attrs []RadiusAttribute) (ExtendedAttribute, int, error) {
+	first := attrs[0]
+	attr := ExtendedAttribute{Type: first.Type}
+	if len(first.Value) < 1 {
+		return attr, 0, errors.New("invalid extended attribute: short buffer")
+	}
+	attr.ExtendedType = first.Value[0]
+	n := 1
+	if isExtended(first.Type) {
+		attr.Value = first.Value[1:]
+	} else {
+		value, used, err := joinFragments(attrs)
+		if err != nil {
+			return attr, 0, err
+		}
+		attr.Value, n = value, used
+	}
+	if attr.ExtendedType == extendedVendorSpecific {
+		if len(attr.Value) < 5 {
+			return attr, 0, errors.New("invalid extended vendor specific attribute: short buffer")
+		}
+		attr.VendorId = binary.BigEndian.Uint32(attr.Value[0:4])
+		attr.VendorType = attr.Value[4]
+		attr.Value = attr.Value[5:]
+	}
+	return attr, n, nil
+}
+
+/*
+ * joinFragments concatenates the values of the Long-Extended-Type fragments
+ * starting at attrs[0]. All fragments must follow each other and have the
+ * same type and extended type, the last one has the "More" flag cleared.
+ */
+func joinFragments(attrs []RadiusAttribute) ([]byte, int, error) {
+	var value []byte
+	for i, attr := range attrs {
+		if attr.Type != attrs[0].Type || len(attr.Value) < 2 || attr.Value[0] != attrs[0].Value[0] {
+			break
+		}
+		more := attr.Value[1]&flagMore != 0
+		if i == 0 && !more {
+			return attr.Value[2:], 1, nil
+		}
+		value = append(value, attr.Value[2:]...)
+		if !more {
+			return value, i + 1, nil
+		}
+	}
+	return nil, 0, errors.New("invalid long extended attribute: missing fragment")
+}
+
+/*
+ * splitFragments returns attrs with the Long-Extended-Type values which do
+ * not fit into one attribute split into fragments, all but the last one
+ * have the "More" flag set.
+ */
+func splitFragments(attrs []RadiusAttribute) []RadiusAttribute {
+	split := make([]RadiusAttribute, 0, len(attrs))
+	for _, attr := range attrs {
+		if !isLongExtended(attr.Type) || len(attr.Value) <= maxAttributeValue {
+			split = append(split, attr)
+			continue
+		}
+		data := attr.Value[2:]
+		for len(data) > 0 {
+			n := len(data)
+			flags := attr.Value[1] &^ flagMore
+			if n > maxAttributeValue-2 {
+				n = maxAttributeValue - 2
+				flags |= flagMore
+			}
+			value := append([]byte{attr.Value[0], flags}, data[:n]...)
+			split = append(split, RadiusAttribute{Type: attr.Type, Length: uint8(2 + len(value)), Value: value})
+			data = data[n:]
+		}
+	}
+	return split
+}
+
+// resolveExtended looks up the helper of an extended attribute by its dotted number
+func resolveExtended(a ExtendedAttribute) resolvedAttribute {
+	number := a.Number()
+	if attr, ok := ExtendedAttributes[number]; ok {
+		return attr.resolve(a.Value)
+	}
+	unsupported := AttributeHelper{Name: "(UNSUPPORTED) " + number, Type: TypeOctets, Parser: parseString}
+	return unsupported.resolve(a.Value)
+}
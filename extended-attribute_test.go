@@ -0,0 +1,84 @@
+package accter
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestExtendedAttribute(t *testing.T) {
+	got := toJsonAttributes(RadiusAttribute{Type: 241, Value: []byte{1, 0, 0, 0, 2}})
+	if len(got) != 1 || got[0].Name != "Frag-Status" || got[0].Value != "2" {
+		t.Errorf("got %v, want [{Frag-Status 2}]", got)
+	}
+}
+
+func TestUnknownExtendedAttribute(t *testing.T) {
+	got := toJsonAttributes(RadiusAttribute{Type: 242, Value: []byte{7, 'a', 'b'}})
+	if len(got) != 1 || got[0].Name != "(UNSUPPORTED) 242.7" || got[0].Value != "ab" {
+		t.Errorf("got %v, want [{(UNSUPPORTED) 242.7 ab}]", got)
+	}
+}
+
+func TestExtendedVendorSpecific(t *testing.T) {
+	got := toJsonAttributes(RadiusAttribute{Type: 241, Value: []byte{26, 0, 0, 0, 9, 3, 'o', 'k'}})
+	if len(got) != 1 || got[0].Name != "(UNSUPPORTED) 241.26.9.3" || got[0].Value != "ok" {
+		t.Errorf("got %v, want [{(UNSUPPORTED) 241.26.9.3 ok}]", got)
+	}
+}
+
+func TestLongExtendedFragments(t *testing.T) {
+	assertion := strings.Repeat("a", 251) + strings.Repeat("b", 100)
+	attrs := []RadiusAttribute{
+		{Type: 1, Value: []byte("user")},
+		{Type: 245, Value: append([]byte{1, flagMore}, assertion[:251]...)},
+		{Type: 245, Value: append([]byte{1, 0}, assertion[251:]...)},
+		{Type: 44, Value: []byte("session")},
+	}
+	resolved := resolveAttributes(attrs)
+	if len(resolved) != 3 {
+		t.Fatalf("got %d attributes, want 3", len(resolved))
+	}
+	got := resolved[1].toJsonAttribute()
+	if got.Name != "SAML-Assertion" || got.Value != assertion {
+		t.Errorf("got %s with %d bytes, want SAML-Assertion with %d bytes", got.Name, len(got.Value), len(assertion))
+	}
+	if resolved[2].helper.Name != "Acct-Session-Id" {
+		t.Errorf("got %s, want Acct-Session-Id", resolved[2].helper.Name)
+	}
+}
+
+func TestParseExtendedAttributes(t *testing.T) {
+	attrs := []RadiusAttribute{
+		{Type: 246, Value: []byte{26, flagMore, 0, 0, 0, 9, 1, 'a'}},
+		{Type: 246, Value: []byte{26, 0, 'b'}},
+		{Type: 241, Value: []byte{2, 0, 0, 0, 10}},
+	}
+	got, err := ParseExtendedAttributes(attrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("got %v, want 2 attributes", got)
+	}
+	if got[0].Number() != "246.26.9.1" || !bytes.Equal(got[0].Value, []byte("ab")) {
+		t.Errorf("got %s=%q, want 246.26.9.1=\"ab\"", got[0].Number(), got[0].Value)
+	}
+	if got[1].Number() != "241.2" || !bytes.Equal(got[1].Value, []byte{0, 0, 0, 10}) {
+		t.Errorf("got %s=%v, want 241.2=[0 0 0 10]", got[1].Number(), got[1].Value)
+	}
+}
+
+func TestParseExtendedAttributesMissingFragment(t *testing.T) {
+	attrs := []RadiusAttribute{
+		{Type: 245, Value: []byte{1, flagMore, 'a'}},
+		{Type: 1, Value: []byte("user")},
+	}
+	if _, err := ParseExtendedAttributes(attrs); err == nil {
+		t.Error("missing fragment not detected")
+	}
+	resolved := resolveAttributes(attrs)
+	if len(resolved) != 2 || resolved[0].helper.Name != "(UNSUPPORTED) 245" {
+		t.Errorf("got %v, want the fragment as unsupported attribute", resolved)
+	}
+}
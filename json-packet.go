@@ -1,19 +1,39 @@
 package accter
 
 type JsonPacket struct {
-	Id            string          `json:"id"`
-	Authenticator string          `json:"authenticator"`
-	Code          string          `json:"code"`
-	Key           string          `json:"key"`
-	RemoteAddr    string          `json:"remote_addr"`
-	Attributes    []JsonAttribute `json:"attributes"`
+	Id            string `json:"id"`
+	Authenticator string `json:"authenticator"`
+	Code          string `json:"code"`
+	Key           string `json:"key"`
+	RemoteAddr    string `json:"remote_addr"`
+	Client        string `json:"client,omitempty"`
+	// Realm is the realm of the User-Name, only set by a RealmRouter
+	Realm      string          `json:"realm,omitempty"`
+	Attributes []JsonAttribute `json:"attributes"`
+	request    *RequestPacket
+	resolved   []resolvedAttribute
 }
 
 type JsonAttribute struct {
 	Name  string `json:"name"`
 	Value string `json:"value"`
+	// Raw is the number of enumerated values like "1" for "Start"
+	Raw string `json:"raw,omitempty"`
+	// Tag is the tunnel tag of tagged attributes as described in RFC 2868, zero if untagged
+	Tag uint8 `json:"tag,omitempty"`
 }
 
 func NewRadiusJsonPacket() *JsonPacket {
 	return &JsonPacket{}
 }
+
+// TunnelGroups returns the tagged attributes grouped by their tag
+func (p *JsonPacket) TunnelGroups() map[uint8][]JsonAttribute {
+	groups := make(map[uint8][]JsonAttribute)
+	for _, attr := range p.Attributes {
+		if attr.Tag != 0 {
+			groups[attr.Tag] = append(groups[attr.Tag], attr)
+		}
+	}
+	return groups
+}
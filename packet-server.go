@@ -1,26 +1,76 @@
 package accter
 
 import (
-	"crypto/md5"
-	"encoding/binary"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-const NETWORK_TYPE = "udp"
+const (
+	NETWORK_TYPE = "udp"
+	// NETWORK_TYPE_TCP is RADIUS over TCP as described in RFC 6613
+	NETWORK_TYPE_TCP = "tcp"
+	// NETWORK_TYPE_TLS is RADIUS over TLS (RadSec) as described in RFC 6614
+	NETWORK_TYPE_TLS = "tls"
+)
 
 type PacketServer struct {
-	Port                int
-	Secret              string
+	Port int
+	// Network is the transport of the server, defaults to NETWORK_TYPE
+	Network string
+	Secret  string
+	// TLSConfig is required for NETWORK_TYPE_TLS, setting ClientCAs enables mutual authentication
+	TLSConfig *tls.Config
+	// IdleTimeoutSeconds closes TCP and TLS connections without packets, defaults to 300
+	IdleTimeoutSeconds int
+	// Clients holds per NAS secrets, if set packets from unknown sources are rejected
+	Clients             *ClientTable
 	AllowRetransmission bool
-	Retransmission      RetransmissionHandler
-	HandleRequest       func(*JsonPacket) error
-	LogLevel            Level
-	Routines            Routines
-	shutdown            chan bool
+	// SkipAuthenticatorCheck disables the verification of the Request Authenticator.
+	// Only use this for NAS firmware which is known to send broken authenticators.
+	SkipAuthenticatorCheck bool
+	// RequireMessageAuthenticator and SendMessageAuthenticator are used without
+	// a client table, see Client for the details.
+	RequireMessageAuthenticator bool
+	SendMessageAuthenticator    bool
+	// DecryptAttributes are the names of salt encrypted attributes like "Tunnel-Password"
+	// which are decrypted with the client secret before they are passed to the handler.
+	DecryptAttributes []string
+	// LogDecryptedValues writes decrypted values to the trace log, they are redacted otherwise
+	LogDecryptedValues bool
+	Retransmission     RetransmissionHandler
+	// SnapshotFile is where the retransmission cache is saved every SnapshotIntervalSeconds
+	// (default 60) and on Shutdown, it is restored when the server starts.
+	SnapshotFile            string
+	SnapshotIntervalSeconds int
+	HandleRequest           func(*JsonPacket) error
+	// HandleRequestWithReply is used instead of HandleRequest if the response
+	// should contain attributes. Proxy-State attributes are echoed automatically.
+	HandleRequestWithReply func(*JsonPacket) ([]RadiusAttribute, error)
+	// Router dispatches requests by realm, routes without handler use the handlers above
+	Router *RealmRouter
+	// HandlerFailureSeconds is how long a handler error marks the server unhealthy
+	// for Status-Server requests, defaults to 30. A succeeding request clears it.
+	HandlerFailureSeconds int
+	// HealthCheck replaces the handler results as source of the health if set
+	HealthCheck func() bool
+	LogLevel    Level
+	Routines    Routines
+	Counters    Counters
+	Health      Health
+	// mu guards shutdown, conn and listener which are set by Listen and Shutdown
+	mu       sync.Mutex
+	shutdown chan bool
+	conn     *net.UDPConn
+	listener net.Listener
+	streams  streamConns
+	// ownsRetransmission is set if the server created the retransmission handler
+	ownsRetransmission bool
+	snapshotMu         sync.Mutex
 }
 
 type Routines struct {
@@ -28,54 +78,155 @@ type Routines struct {
 	count int
 }
 
-func (s *PacketServer) Serve() error {
-	s.shutdown = make(chan bool)
-	if s.HandleRequest == nil {
-		return errors.New("server has no handler")
+// Health is the state reported to Status-Server requests
+type Health struct {
+	// lastFailure is the time of the last handler error in unix nanoseconds, 0 after a success
+	lastFailure atomic.Int64
+}
+
+type Counters struct {
+	invalidAuthenticators atomic.Uint64
+	malformedPackets      atomic.Uint64
+	unknownClients        atomic.Uint64
+	unknownRealms         atomic.Uint64
+}
+
+/*
+ * Listen checks the configuration and binds the socket of the server, it is
+ * ready to receive packets once Listen returned. Serve calls it unless it was
+ * called before.
+ */
+func (s *PacketServer) Listen() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.shutdown == nil {
+		s.shutdown = make(chan bool)
+	}
+	select {
+	case <-s.shutdown:
+		return errors.New("server is shut down")
+	default:
 	}
-	if s.Secret == "" {
+	if s.conn != nil || s.listener != nil {
+		return nil
+	}
+	if s.HandleRequest == nil && s.HandleRequestWithReply == nil {
+		if s.Router == nil {
+			return errors.New("server has no handler")
+		}
+		if err := s.Router.checkHandlers(); err != nil {
+			return err
+		}
+	}
+	if s.Network == "" {
+		s.Network = NETWORK_TYPE
+	}
+	if s.Secret == "" && s.Clients == nil && s.Network != NETWORK_TYPE_TLS {
 		return errors.New("server has no secret source")
 	}
 	if s.Retransmission == nil && !s.AllowRetransmission {
 		s.Retransmission = CreateLocalRetransmissionHandler()
-	}
-	if s.Port == 0 {
-		s.Port = 1813
+		s.ownsRetransmission = true
 	}
 	if s.LogLevel == 0 {
 		CreateLogger(Info)
 	} else {
 		CreateLogger(s.LogLevel)
 	}
+	if s.IdleTimeoutSeconds == 0 {
+		s.IdleTimeoutSeconds = 300
+	}
+	if s.HandlerFailureSeconds == 0 {
+		s.HandlerFailureSeconds = 30
+	}
+	var snapshotter RetransmissionSnapshotter
+	var err error
+	if s.SnapshotFile != "" && s.Retransmission != nil {
+		if snapshotter, err = s.loadSnapshot(); err != nil {
+			return err
+		}
+	}
+	switch s.Network {
+	case NETWORK_TYPE:
+		if s.Port == 0 {
+			s.Port = 1813
+		}
+		s.conn, err = s.listenUDP()
+	case NETWORK_TYPE_TCP:
+		if s.Port == 0 {
+			s.Port = 1813
+		}
+		s.listener, err = s.listenTCP()
+	case NETWORK_TYPE_TLS:
+		if s.Port == 0 {
+			s.Port = 2083
+		}
+		s.listener, err = s.listenTLS()
+	default:
+		err = fmt.Errorf("unsupported network type %q", s.Network)
+	}
+	if err != nil {
+		return err
+	}
+	if snapshotter != nil {
+		go s.snapshotCycle(snapshotter, s.shutdown)
+	}
+	return nil
+}
+
+func (s *PacketServer) Serve() error {
+	if err := s.Listen(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	conn, ln := s.conn, s.listener
+	s.mu.Unlock()
+	if conn != nil {
+		return s.serveUDP(conn)
+	}
+	return s.serveStream(ln)
+}
+
+func (s *PacketServer) listenUDP() (*net.UDPConn, error) {
 	logger.info("starting server on :%d", s.Port)
 	conn, err := net.ListenUDP(NETWORK_TYPE, &net.UDPAddr{Port: s.Port})
 	if err != nil {
-		return fmt.Errorf("listen to UDP failed with: %v", err)
+		return nil, fmt.Errorf("listen to UDP failed with: %v", err)
 	}
+	return conn, nil
+}
+
+func (s *PacketServer) serveUDP(conn *net.UDPConn) error {
 	defer conn.Close()
 	for {
 		var buff = make([]byte, MaxPacketLength)
 		n, remoteAddr, err := conn.ReadFromUDP(buff[:])
-		if err != nil {
-			logger.error("error reading from connection: %v", err)
-			continue
-		}
 		// breake if we got shutdown signal
 		select {
 		case <-s.shutdown:
 			logger.info("shutdown signal received, shutting down")
+			s.waitForRoutines()
 			return nil
 		default:
+			if err != nil {
+				logger.error("error reading from connection: %v", err)
+				continue
+			}
+			s.IncrementRoutineCount()
 			go func(buff []byte, remoteAddr net.Addr) {
-				s.IncrementRoutineCount()
 				defer s.DecreaseRoutineCount()
-				res, err := s.handlePacket(buff, remoteAddr)
+				client, err := s.lookupClient(remoteAddr)
+				if err != nil {
+					logger.warn("rejecting packet: %v", err)
+					return
+				}
+				res, err := s.handlePacket(buff, source{addr: remoteAddr, client: client})
 				if err != nil {
 					logger.warn("processing packet faild with: %v", err)
 					return
 				}
 				if res == nil {
-					// this a retransmission => no response
+					// this a retransmission or a withheld status => no response
 					return
 				}
 				if _, err := conn.WriteTo(res, remoteAddr); err != nil {
@@ -87,21 +238,50 @@ func (s *PacketServer) Serve() error {
 	}
 }
 
+// Shutdown stops the server and waits for the running handlers, it can be called before Serve
 func (s *PacketServer) Shutdown() {
-	s.shutdown <- true
-	for s.GetRoutineCount() > 0 {
-		time.Sleep(1 * time.Second)
+	s.mu.Lock()
+	if s.shutdown == nil {
+		s.shutdown = make(chan bool)
+	}
+	select {
+	case <-s.shutdown:
+		s.mu.Unlock()
+		return
+	default:
+		close(s.shutdown)
+	}
+	conn, ln := s.conn, s.listener
+	s.mu.Unlock()
+	if conn == nil && ln == nil {
+		// the server never listened, there is nothing to stop
+		return
+	}
+	if conn != nil {
+		// unblock the pending read, the connection stays open for the running routines
+		conn.SetReadDeadline(time.Now())
+	}
+	if ln != nil {
+		ln.Close()
+		s.streams.stopReading()
+	}
+	s.waitForRoutines()
+	if snapshotter, ok := s.Retransmission.(RetransmissionSnapshotter); ok && s.SnapshotFile != "" {
+		s.saveSnapshot(snapshotter)
+	}
+	if local, ok := s.Retransmission.(*Retransmissions); ok && s.ownsRetransmission {
+		local.Stop()
 	}
 	logger.info("all routines finished, server shutdown")
 }
 
-func (s *PacketServer) IncrementRoutineCount() {
-	if s.Routines == (Routines{}) {
-		s.Routines = Routines{
-			mu:    sync.RWMutex{},
-			count: 0,
-		}
+func (s *PacketServer) waitForRoutines() {
+	for s.GetRoutineCount() > 0 {
+		time.Sleep(100 * time.Millisecond)
 	}
+}
+
+func (s *PacketServer) IncrementRoutineCount() {
 	s.Routines.mu.Lock()
 	defer s.Routines.mu.Unlock()
 	s.Routines.count++
@@ -115,6 +295,7 @@ func (s *PacketServer) DecreaseRoutineCount() {
 		logger.warn("routine count is already 0, something is wrong")
 		return
 	}
+	s.Routines.count--
 	logger.trace("routine finished, now we have %d active routines", s.Routines.count)
 }
 
@@ -124,60 +305,225 @@ func (s *PacketServer) GetRoutineCount() int {
 	return s.Routines.count
 }
 
-func (s *PacketServer) handlePacket(b []byte, remoteAddr net.Addr) ([]byte, error) {
+/*
+ * IsHealthy reports false while the server is shutting down. Otherwise it
+ * returns the result of HealthCheck if set, else false for HandlerFailureSeconds
+ * after a call of the handler returned an error.
+ */
+func (s *PacketServer) IsHealthy() bool {
+	s.mu.Lock()
+	shutdown := s.shutdown
+	s.mu.Unlock()
+	select {
+	case <-shutdown:
+		return false
+	default:
+	}
+	if s.HealthCheck != nil {
+		return s.HealthCheck()
+	}
+	failed := s.Health.lastFailure.Load()
+	return failed == 0 || time.Since(time.Unix(0, failed)) >= time.Duration(s.HandlerFailureSeconds)*time.Second
+}
+
+func (s *PacketServer) GetInvalidAuthenticatorCount() uint64 {
+	return s.Counters.invalidAuthenticators.Load()
+}
+
+// GetMalformedPacketCount returns the number of packets which could not be parsed
+func (s *PacketServer) GetMalformedPacketCount() uint64 {
+	return s.Counters.malformedPackets.Load()
+}
+
+func (s *PacketServer) GetUnknownClientCount() uint64 {
+	return s.Counters.unknownClients.Load()
+}
+
+// GetUnknownRealmCount returns the number of requests without route, including the accepted ones
+func (s *PacketServer) GetUnknownRealmCount() uint64 {
+	return s.Counters.unknownRealms.Load()
+}
+
+/*
+ * lookupClient returns the client for the source address. Without a client
+ * table every source is accepted and uses the server secret.
+ */
+func (s *PacketServer) lookupClient(remoteAddr net.Addr) (*Client, error) {
+	if s.Clients == nil {
+		return &Client{
+			Secret:                      s.Secret,
+			RequireMessageAuthenticator: s.RequireMessageAuthenticator,
+			SendMessageAuthenticator:    s.SendMessageAuthenticator,
+		}, nil
+	}
+	if client := s.Clients.Lookup(addrIP(remoteAddr)); client != nil {
+		return client, nil
+	}
+	s.Counters.unknownClients.Add(1)
+	return nil, fmt.Errorf("unknown client [%s]", remoteAddr)
+}
+
+// source describes where a packet was received from
+type source struct {
+	addr   net.Addr
+	client *Client
+	// stream is set for TCP based transports which do not retransmit on the same connection
+	stream bool
+}
+
+func (s *PacketServer) handlePacket(b []byte, src source) (response []byte, err error) {
+	remoteAddr, client := src.addr, src.client
+	if len(b) < 20 {
+		s.Counters.malformedPackets.Add(1)
+		return nil, fmt.Errorf("packet of %d bytes from %s is too short, packet dropped", len(b), remoteAddr)
+	}
 	jsonPacket := NewRadiusJsonPacket()
 	logger.debug(fmt.Sprintf("received packet from: %s with id: %#x", remoteAddr, b[1]))
 	jsonPacket.RemoteAddr = remoteAddr.String()
-	packet, err := ParsePacket(b, []byte(s.Secret))
+	jsonPacket.Client = client.Name
+	packet, err := ParsePacket(b, []byte(client.Secret))
 	if err != nil {
-		err := fmt.Errorf(fmt.Sprintf("[packet-%#x] unable to parse bytes: %v", err, b[1]))
+		s.Counters.malformedPackets.Add(1)
+		err := fmt.Errorf("[packet-%#x] unable to parse bytes: %v", b[1], err)
 		return nil, err
 	}
 	jsonPacket.Id = fmt.Sprintf("%#x", b[1])
 	jsonPacket.Authenticator = fmt.Sprintf("%x", b[4:20])
 	jsonPacket.Code = Code(b[0]).String()
 	jsonPacket.Key = jsonPacket.Id + "_" + jsonPacket.Authenticator
+	jsonPacket.request = packet
+	if packet.Code == CodeStatusServer {
+		return s.handleStatusServer(packet, remoteAddr)
+	}
 	if packet.Code != CodeAccountingRequest {
-		err := fmt.Errorf("[packet-%#x] only accounting request is supported", b[1])
+		err := fmt.Errorf("[packet-%#x] only accounting request and status server are supported", b[1])
+		return nil, err
+	}
+	if !s.SkipAuthenticatorCheck && !packet.VerifyAuthenticator() {
+		s.Counters.invalidAuthenticators.Add(1)
+		err := fmt.Errorf("[packet-%#x] invalid request authenticator from %s, packet dropped", b[1], remoteAddr)
+		return nil, err
+	}
+	if packet.hasMessageAuthenticator() && !packet.VerifyMessageAuthenticator() {
+		s.Counters.invalidAuthenticators.Add(1)
+		err := fmt.Errorf("[packet-%#x] invalid message authenticator from %s, packet dropped", b[1], remoteAddr)
 		return nil, err
 	}
-	if !s.AllowRetransmission {
-		logger.trace(fmt.Sprintf("[packet-%#x] checking retransmission key: %s", b[1], jsonPacket.Key))
-		isRetry := s.Retransmission.IsRetransmission(jsonPacket.Key)
-		if isRetry {
-			logger.debug(fmt.Sprintf("[packet-%#x] retransmission detected with key: %s", b[0], jsonPacket.Key))
+	if client.RequireMessageAuthenticator && !packet.hasMessageAuthenticator() {
+		err := fmt.Errorf("[packet-%#x] missing message authenticator from %s, packet dropped", b[1], remoteAddr)
+		return nil, err
+	}
+	if client.NasIdentifier != "" && !hasAttribute(packet, typeNasIdentifier, client.NasIdentifier) {
+		err := fmt.Errorf("[packet-%#x] NAS-Identifier does not match client [%s], packet dropped", b[1], client.Name)
+		return nil, err
+	}
+	// the source is part of the key as recommended in RFC 5080 section 2.2.2
+	cacheKey := remoteAddr.String() + "_" + jsonPacket.Key
+	detectDuplicates := !s.AllowRetransmission && !src.stream
+	if detectDuplicates {
+		logger.trace(fmt.Sprintf("[packet-%#x] checking retransmission key: %s", b[1], cacheKey))
+		switch state, cached := s.Retransmission.Begin(cacheKey); state {
+		case RequestCompleted:
+			logger.debug(fmt.Sprintf("[packet-%#x] retransmission detected with key: %s, replaying response", b[1], cacheKey))
+			return cached, nil
+		case RequestInFlight:
+			logger.debug(fmt.Sprintf("[packet-%#x] retransmission detected with key: %s, request still in flight", b[1], cacheKey))
 			return nil, nil
-		} else {
-			s.Retransmission.AddToCache(jsonPacket.Key)
 		}
+		defer func() {
+			if err != nil {
+				s.Retransmission.Fail(cacheKey)
+			} else {
+				s.Retransmission.Complete(cacheKey, response)
+			}
+		}()
 	}
 	logger.trace(fmt.Sprintf("[packet-%#x] transform attributes as strings", b[1]))
-	for _, v := range packet.Attributes {
-		if attr, ok := Attributes[int(v.Type)]; ok {
-			val := attr.Parser(v.Value)
-			a := JsonAttribute{Name: attr.Name, Value: val}
-			jsonPacket.Attributes = append(jsonPacket.Attributes, a)
-			logger.trace(fmt.Sprintf("[packet-%#x] add attr: %s='%s',", b[1], attr.Name, val))
-		} else {
-			a := JsonAttribute{Name: fmt.Sprintf("(UNSUPPORTED) %d", v.Type), Value: string(v.Value)}
-			jsonPacket.Attributes = append(jsonPacket.Attributes, a)
-			logger.trace(fmt.Sprintf("[packet-%#x] %d='%v (UNSUPPORTED)'", b[1], v.Type, string(v.Value)))
+	jsonPacket.resolved = resolveAttributes(packet.Attributes)
+	for i := range jsonPacket.resolved {
+		r := &jsonPacket.resolved[i]
+		if r.helper.Encrypted && s.decrypts(r.helper.Name) {
+			if err := r.decrypt(packet); err != nil {
+				logger.debug(fmt.Sprintf("[packet-%#x] decrypting %s failed: %v", b[1], r.helper.Name, err))
+			}
 		}
+		a := r.toJsonAttribute()
+		jsonPacket.Attributes = append(jsonPacket.Attributes, a)
+		value := a.Value
+		if r.decrypted && !s.LogDecryptedValues {
+			value = "<redacted>"
+		}
+		logger.trace(fmt.Sprintf("[packet-%#x] add attr: %s='%s',", b[1], a.Name, value))
+	}
+	attrs, err := s.handleRequest(jsonPacket)
+	if errors.Is(err, ErrDrop) {
+		logger.debug(fmt.Sprintf("[packet-%#x] response withheld: %v", b[1], err))
+		return nil, nil
+	}
+	if errors.Is(err, ErrUnknownRealm) {
+		return nil, fmt.Errorf("[packet-%#x] %v, packet rejected", b[1], err)
 	}
-	err = s.HandleRequest(jsonPacket)
 	if err != nil {
+		s.Health.lastFailure.Store(time.Now().UnixNano())
 		return nil, err
-	} else {
-		writebytes := make([]byte, 20)
-		writebytes[0] = byte(CodeAccountingResponse)
-		writebytes[1] = packet.Identifier
-		binary.BigEndian.PutUint16(writebytes[2:4], 20)
-		hash := md5.New()
-		hash.Write(writebytes[:4])
-		hash.Write(packet.Authenticator[:])
-		hash.Write(packet.Secret)
-		hash.Sum(writebytes[4:4:20])
-		logger.trace(fmt.Sprintf("[packet-%#x] send response", b[1]))
-		return writebytes, nil
 	}
+	s.Health.lastFailure.Store(0)
+	attrs = append(attrs, packet.proxyStates()...)
+	if client.SendMessageAuthenticator || packet.hasMessageAuthenticator() {
+		// the Message-Authenticator comes first as recommended against BlastRADIUS
+		attrs = append([]RadiusAttribute{newMessageAuthenticator()}, attrs...)
+	}
+	logger.trace(fmt.Sprintf("[packet-%#x] send response", b[1]))
+	return encodeResponse(CodeAccountingResponse, packet, attrs)
+}
+
+func (s *PacketServer) decrypts(name string) bool {
+	for _, n := range s.DecryptAttributes {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+ * handleRequest passes the request to the handler of its realm route or to
+ * the handlers of the server if there is no router.
+ */
+func (s *PacketServer) handleRequest(jsonPacket *JsonPacket) ([]RadiusAttribute, error) {
+	if s.Router != nil {
+		route, err := s.Router.route(jsonPacket)
+		if route == nil {
+			s.Counters.unknownRealms.Add(1)
+			return nil, err
+		}
+		if route.Handler != nil {
+			return nil, route.Handler(jsonPacket)
+		}
+	}
+	if s.HandleRequestWithReply != nil {
+		return s.HandleRequestWithReply(jsonPacket)
+	}
+	if s.HandleRequest != nil {
+		return nil, s.HandleRequest(jsonPacket)
+	}
+	return nil, errors.New("realm route has no handler")
+}
+
+/*
+ * handleStatusServer answers health checks as described in RFC 5997. The
+ * response is withheld if the server is not healthy.
+ */
+func (s *PacketServer) handleStatusServer(packet *RequestPacket, remoteAddr net.Addr) ([]byte, error) {
+	if !packet.VerifyMessageAuthenticator() {
+		s.Counters.invalidAuthenticators.Add(1)
+		err := fmt.Errorf("[packet-%#x] status server from %s without valid message authenticator, packet dropped", packet.Identifier, remoteAddr)
+		return nil, err
+	}
+	if !s.IsHealthy() {
+		logger.debug(fmt.Sprintf("[packet-%#x] server is not healthy, status server not answered", packet.Identifier))
+		return nil, nil
+	}
+	logger.trace(fmt.Sprintf("[packet-%#x] send status response", packet.Identifier))
+	return encodeResponse(CodeAccountingResponse, packet, []RadiusAttribute{newMessageAuthenticator()})
 }
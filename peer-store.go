@@ -0,0 +1,302 @@
+package accter
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	peerSet    byte = 1
+	peerDelete byte = 2
+	// peerHeaderLength is the operation, the sender, the sequence number, the
+	// time in unix milliseconds, the ttl in milliseconds and the key length
+	peerHeaderLength = 1 + 8 + 8 + 8 + 4 + 2
+	// peerMaxAge is the difference to the local clock after which updates are ignored
+	peerMaxAge = 30 * time.Second
+	// peerWindowSize is the number of sequence numbers below the highest one which may arrive late
+	peerWindowSize = 64
+)
+
+/*
+ * PeerStore is a KVStore in memory which replicates its changes to peers over
+ * UDP, so several accter instances share their state without an external
+ * service. The updates are signed with HMAC-SHA256 of the shared secret,
+ * updates with a wrong signature are ignored. Every update carries a random
+ * sender id, a sequence number and the time it was sent, updates received
+ * before from the same sender or more than 30 seconds off the local clock
+ * are ignored, so captured updates can not be replayed. Replication is best effort
+ * and not synchronous, SetIfAbsent is only atomic on the local instance. A
+ * retransmission to another instance is detected once the update of the
+ * first instance arrived, which is usually long before the NAS retransmits.
+ */
+type PeerStore struct {
+	peers   []*net.UDPAddr
+	secret  []byte
+	conn    *net.UDPConn
+	mu      sync.Mutex
+	entries map[string]peerEntry
+	// sender identifies the updates of this instance, sequence numbers them
+	sender   uint64
+	sequence atomic.Uint64
+	// windows holds the sequence numbers received per sender
+	windows map[uint64]*peerWindow
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+type peerEntry struct {
+	value   []byte
+	expires time.Time
+}
+
+// peerWindow tracks the last peerWindowSize sequence numbers received from a sender
+type peerWindow struct {
+	last     uint64
+	seen     uint64
+	received time.Time
+}
+
+// accept reports whether sequence was not received before and marks it as received
+func (w *peerWindow) accept(sequence uint64) bool {
+	if sequence > w.last {
+		if shift := sequence - w.last; shift < peerWindowSize {
+			w.seen = w.seen<<shift | 1
+		} else {
+			w.seen = 1
+		}
+		w.last = sequence
+		return true
+	}
+	offset := w.last - sequence
+	if offset >= peerWindowSize || w.seen&(1<<offset) != 0 {
+		return false
+	}
+	w.seen |= 1 << offset
+	return true
+}
+
+/*
+ * ListenPeerStore listens on the UDP address like ":1814" for the updates of
+ * the peers and sends its own updates to the peer addresses.
+ */
+func ListenPeerStore(addr, secret string, peers ...string) (*PeerStore, error) {
+	if secret == "" {
+		return nil, errors.New("peer store has no secret")
+	}
+	s := &PeerStore{
+		secret:  []byte(secret),
+		entries: make(map[string]peerEntry),
+		windows: make(map[uint64]*peerWindow),
+		done:    make(chan struct{}),
+	}
+	var sender [8]byte
+	if _, err := rand.Read(sender[:]); err != nil {
+		return nil, err
+	}
+	s.sender = binary.BigEndian.Uint64(sender[:])
+	for _, peer := range peers {
+		udpAddr, err := net.ResolveUDPAddr("udp", peer)
+		if err != nil {
+			return nil, err
+		}
+		s.peers = append(s.peers, udpAddr)
+	}
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if s.conn, err = net.ListenUDP("udp", udpAddr); err != nil {
+		return nil, err
+	}
+	s.wg.Add(2)
+	go s.receive()
+	go s.purge()
+	return s, nil
+}
+
+// LocalAddr returns the address the updates of the peers are received on
+func (s *PeerStore) LocalAddr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+func (s *PeerStore) SetIfAbsent(key string, value []byte, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	if entry, ok := s.entries[key]; ok && time.Now().Before(entry.expires) {
+		s.mu.Unlock()
+		return false, nil
+	}
+	s.entries[key] = peerEntry{value: value, expires: time.Now().Add(ttl)}
+	s.mu.Unlock()
+	s.replicate(peerSet, key, value, ttl)
+	return true, nil
+}
+
+func (s *PeerStore) Set(key string, value []byte, ttl time.Duration) error {
+	s.set(key, value, ttl)
+	s.replicate(peerSet, key, value, ttl)
+	return nil
+}
+
+func (s *PeerStore) Get(key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[key]
+	if !ok || !time.Now().Before(entry.expires) {
+		return nil, nil
+	}
+	return entry.value, nil
+}
+
+func (s *PeerStore) Delete(key string) error {
+	s.delete(key)
+	s.replicate(peerDelete, key, nil, 0)
+	return nil
+}
+
+// Close stops receiving updates, the entries are kept until the store is garbage collected
+func (s *PeerStore) Close() error {
+	close(s.done)
+	err := s.conn.Close()
+	s.wg.Wait()
+	return err
+}
+
+func (s *PeerStore) set(key string, value []byte, ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[key] = peerEntry{value: value, expires: time.Now().Add(ttl)}
+}
+
+func (s *PeerStore) delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+}
+
+// replicate sends the update to all peers, failed sends are not repeated
+func (s *PeerStore) replicate(op byte, key string, value []byte, ttl time.Duration) {
+	if len(key) > 0xffff {
+		return
+	}
+	b := s.update(op, key, value, ttl)
+	for _, peer := range s.peers {
+		s.conn.WriteToUDP(b, peer)
+	}
+}
+
+// update returns the signed update with the next sequence number
+func (s *PeerStore) update(op byte, key string, value []byte, ttl time.Duration) []byte {
+	b := make([]byte, peerHeaderLength, peerHeaderLength+len(key)+len(value)+sha256.Size)
+	b[0] = op
+	binary.BigEndian.PutUint64(b[1:9], s.sender)
+	binary.BigEndian.PutUint64(b[9:17], s.sequence.Add(1))
+	binary.BigEndian.PutUint64(b[17:25], uint64(time.Now().UnixMilli()))
+	binary.BigEndian.PutUint32(b[25:29], uint32(ttl.Milliseconds()))
+	binary.BigEndian.PutUint16(b[29:31], uint16(len(key)))
+	b = append(b, key...)
+	b = append(b, value...)
+	return append(b, s.mac(b)...)
+}
+
+func (s *PeerStore) mac(b []byte) []byte {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write(b)
+	return mac.Sum(nil)
+}
+
+func (s *PeerStore) receive() {
+	defer s.wg.Done()
+	buff := make([]byte, 65535)
+	for {
+		n, _, err := s.conn.ReadFromUDP(buff)
+		if err != nil {
+			select {
+			case <-s.done:
+				return
+			default:
+				continue
+			}
+		}
+		s.apply(buff[:n])
+	}
+}
+
+// apply applies a signed update of a peer and ignores invalid, stale and replayed ones
+func (s *PeerStore) apply(b []byte) {
+	if len(b) < peerHeaderLength+sha256.Size {
+		return
+	}
+	signed, sum := b[:len(b)-sha256.Size], b[len(b)-sha256.Size:]
+	if !hmac.Equal(s.mac(signed), sum) {
+		return
+	}
+	keyLength := int(binary.BigEndian.Uint16(signed[29:31]))
+	if peerHeaderLength+keyLength > len(signed) {
+		return
+	}
+	sent := time.UnixMilli(int64(binary.BigEndian.Uint64(signed[17:25])))
+	if age := time.Since(sent); age > peerMaxAge || age < -peerMaxAge {
+		return
+	}
+	if !s.accept(binary.BigEndian.Uint64(signed[1:9]), binary.BigEndian.Uint64(signed[9:17])) {
+		return
+	}
+	key := string(signed[peerHeaderLength : peerHeaderLength+keyLength])
+	switch signed[0] {
+	case peerSet:
+		ttl := time.Duration(binary.BigEndian.Uint32(signed[25:29])) * time.Millisecond
+		value := append([]byte(nil), signed[peerHeaderLength+keyLength:]...)
+		s.set(key, value, ttl)
+	case peerDelete:
+		s.delete(key)
+	}
+}
+
+// accept reports whether the sequence number of sender was not received before
+func (s *PeerStore) accept(sender, sequence uint64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w := s.windows[sender]
+	if w == nil {
+		w = &peerWindow{}
+		s.windows[sender] = w
+	}
+	w.received = time.Now()
+	return w.accept(sequence)
+}
+
+/*
+ * purge removes the expired entries every second. The window of a sender is
+ * kept until its updates are rejected as stale, even if the clocks differ.
+ */
+func (s *PeerStore) purge() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case now := <-ticker.C:
+			s.mu.Lock()
+			for key, entry := range s.entries {
+				if !now.Before(entry.expires) {
+					delete(s.entries, key)
+				}
+			}
+			for sender, w := range s.windows {
+				if now.Sub(w.received) > 2*peerMaxAge {
+					delete(s.windows, sender)
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package accter
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+// StartPeerStores starts two stores which replicate to each other
+func StartPeerStores(t *testing.T, secretA, secretB string) (*PeerStore, *PeerStore) {
+	a, err := ListenPeerStore("127.0.0.1:0", secretA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ListenPeerStore("127.0.0.1:0", secretB, a.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.peers = append(a.peers, b.conn.LocalAddr().(*net.UDPAddr))
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+	return a, b
+}
+
+// WaitForValue waits until the store has the wanted value for key
+func WaitForValue(store KVStore, key string, want []byte) []byte {
+	var value []byte
+	for i := 0; i < 50; i++ {
+		if value, _ = store.Get(key); bytes.Equal(value, want) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return value
+}
+
+func TestPeerStore(t *testing.T) {
+	a, b := StartPeerStores(t, "secret", "secret")
+	if stored, _ := a.SetIfAbsent("foo", []byte("bar"), time.Minute); !stored {
+		t.Fatal("SetIfAbsent() of a new key = false")
+	}
+	if value := WaitForValue(b, "foo", []byte("bar")); !bytes.Equal(value, []byte("bar")) {
+		t.Fatalf("replicated value = %q, want %q", value, "bar")
+	}
+	if stored, _ := b.SetIfAbsent("foo", []byte("baz"), time.Minute); stored {
+		t.Error("SetIfAbsent() of a replicated key = true")
+	}
+	b.Set("foo", []byte("baz"), time.Minute)
+	if value := WaitForValue(a, "foo", []byte("baz")); !bytes.Equal(value, []byte("baz")) {
+		t.Errorf("replicated value = %q, want %q", value, "baz")
+	}
+	a.Delete("foo")
+	if value := WaitForValue(b, "foo", nil); value != nil {
+		t.Errorf("replicated delete left %q", value)
+	}
+	a.Set("short", []byte("lived"), 50*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	if value, _ := b.Get("short"); value != nil {
+		t.Errorf("Get() of an expired key = %q, want nil", value)
+	}
+}
+
+func TestPeerStoreWrongSecret(t *testing.T) {
+	a, b := StartPeerStores(t, "secret", "other")
+	a.Set("foo", []byte("bar"), time.Minute)
+	time.Sleep(200 * time.Millisecond)
+	if value, _ := b.Get("foo"); value != nil {
+		t.Errorf("update with a wrong signature applied: %q", value)
+	}
+	b.apply([]byte{peerSet, 0, 0})
+	if _, err := ListenPeerStore("127.0.0.1:0", ""); err == nil {
+		t.Error("store without secret started")
+	}
+}
+
+func TestPeerStoreReplay(t *testing.T) {
+	a, b := StartPeerStores(t, "secret", "secret")
+	update := a.update(peerSet, "foo", []byte("bar"), time.Minute)
+	b.apply(update)
+	if value, _ := b.Get("foo"); string(value) != "bar" {
+		t.Fatalf("Get() = %q, want %q", value, "bar")
+	}
+	b.delete("foo")
+	b.apply(update)
+	if value, _ := b.Get("foo"); value != nil {
+		t.Errorf("replayed update applied: %q", value)
+	}
+
+	// updates may arrive out of order within the window
+	first := a.update(peerSet, "first", []byte("1"), time.Minute)
+	second := a.update(peerSet, "second", []byte("2"), time.Minute)
+	b.apply(second)
+	b.apply(first)
+	if value, _ := b.Get("first"); string(value) != "1" {
+		t.Errorf("update arriving late not applied: %q", value)
+	}
+
+	stale := a.update(peerSet, "stale", []byte("old"), time.Minute)
+	signed := stale[:len(stale)-sha256.Size]
+	binary.BigEndian.PutUint64(signed[17:25], uint64(time.Now().Add(-time.Minute).UnixMilli()))
+	b.apply(append(signed, a.mac(signed)...))
+	if value, _ := b.Get("stale"); value != nil {
+		t.Errorf("stale update applied: %q", value)
+	}
+}
+
+func TestPeerWindow(t *testing.T) {
+	w := &peerWindow{}
+	tests := []struct {
+		sequence uint64
+		want     bool
+	}{
+		{1, true},
+		{1, false},
+		{3, true},
+		{2, true},
+		{2, false},
+		{100, true},
+		{3, false},
+		{37, true},
+		{36, false},
+		{37, false},
+		{101, true},
+	}
+	for _, test := range tests {
+		if got := w.accept(test.sequence); got != test.want {
+			t.Errorf("accept(%d) = %v, want %v", test.sequence, got, test.want)
+		}
+	}
+}
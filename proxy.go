@@ -0,0 +1,118 @@
+package accter
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultDeadTime is the time an upstream which did not respond is tried last
+const DefaultDeadTime = 30 * time.Second
+
+/*
+ * Proxy forwards accounting requests to upstream servers. Use HandleRequest
+ * as handler of the PacketServer, the NAS gets its Accounting-Response only
+ * once an upstream acknowledged the request. The request is signed with the
+ * secret of the upstream and a Proxy-State is appended as described in RFC
+ * 2865 section 5.33. Upstreams which did not respond are marked dead and
+ * tried after the alive ones until DeadTime passed or they responded again.
+ * Salt encrypted attributes are forwarded unchanged, they can not be
+ * encrypted again for the upstream because the Request Authenticator they
+ * are keyed with covers them. The upstream can not decrypt them, set
+ * DecryptAttributes on the server to pass the plain values to Handler.
+ */
+type Proxy struct {
+	Upstreams []AccountingServer
+	// Timeout and Retries are used per upstream, see AccountingClient
+	Timeout time.Duration
+	Retries int
+	// DeadTime defaults to DefaultDeadTime
+	DeadTime time.Duration
+	// Handler is called before the request is forwarded, an error drops the request
+	Handler func(*JsonPacket) error
+	client  AccountingClient
+	once    sync.Once
+	states  atomic.Uint64
+	mu      sync.Mutex
+	dead    map[string]time.Time
+}
+
+// HandleRequest forwards the request and returns an error if no upstream acknowledged it
+func (p *Proxy) HandleRequest(packet *JsonPacket) error {
+	if packet.request == nil {
+		return errors.New("packet was not received by a server")
+	}
+	if p.Handler != nil {
+		if err := p.Handler(packet); err != nil {
+			return err
+		}
+	}
+	p.once.Do(func() {
+		p.client.Timeout, p.client.Retries = p.Timeout, p.Retries
+	})
+	attrs := append([]RadiusAttribute(nil), packet.request.Attributes...)
+	attrs = append(attrs, p.newProxyState())
+	_, err := p.client.send(context.Background(), p.upstreams(), attrs, p.report)
+	return err
+}
+
+// DeadUpstreams returns the addresses of the upstreams which are currently marked dead
+func (p *Proxy) DeadUpstreams() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var dead []string
+	for _, upstream := range p.Upstreams {
+		if p.isDead(upstream.Addr) {
+			dead = append(dead, upstream.Addr)
+		}
+	}
+	return dead
+}
+
+// upstreams returns the alive upstreams followed by the dead ones
+func (p *Proxy) upstreams() []AccountingServer {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	alive := make([]AccountingServer, 0, len(p.Upstreams))
+	var dead []AccountingServer
+	for _, upstream := range p.Upstreams {
+		if p.isDead(upstream.Addr) {
+			dead = append(dead, upstream)
+		} else {
+			alive = append(alive, upstream)
+		}
+	}
+	return append(alive, dead...)
+}
+
+// isDead must be called with p.mu held
+func (p *Proxy) isDead(addr string) bool {
+	until, ok := p.dead[addr]
+	return ok && time.Now().Before(until)
+}
+
+func (p *Proxy) report(upstream AccountingServer, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err == nil {
+		delete(p.dead, upstream.Addr)
+		return
+	}
+	if p.dead == nil {
+		p.dead = make(map[string]time.Time)
+	}
+	deadTime := p.DeadTime
+	if deadTime <= 0 {
+		deadTime = DefaultDeadTime
+	}
+	p.dead[upstream.Addr] = time.Now().Add(deadTime)
+}
+
+// newProxyState returns a Proxy-State which is unique for the lifetime of the proxy
+func (p *Proxy) newProxyState() RadiusAttribute {
+	value := binary.BigEndian.AppendUint64(nil, p.states.Add(1))
+	return RadiusAttribute{Type: typeProxyState, Length: uint8(2 + len(value)), Value: value}
+}
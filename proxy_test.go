@@ -0,0 +1,148 @@
+package accter
+
+import (
+	"context"
+	"encoding/binary"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// NewUpstreamServer returns a server with the secret "upstream" which reports its packets on the channel
+func NewUpstreamServer() (*PacketServer, chan *JsonPacket) {
+	packets := make(chan *JsonPacket, 10)
+	server := &PacketServer{
+		Secret:              "upstream",
+		AllowRetransmission: true,
+		HandleRequest: func(packet *JsonPacket) error {
+			packets <- packet
+			return nil
+		},
+	}
+	return server, packets
+}
+
+// StartUpstreamServer starts the server of NewUpstreamServer
+func StartUpstreamServer(t *testing.T) (chan *JsonPacket, string) {
+	server, packets := NewUpstreamServer()
+	return packets, StartTestServer(t, server)
+}
+
+func TestProxy(t *testing.T) {
+	packets, upstream := StartUpstreamServer(t)
+	logged := make(chan string, 1)
+	proxy := &Proxy{
+		Upstreams: []AccountingServer{{Addr: upstream, Secret: "upstream"}},
+		Handler: func(packet *JsonPacket) error {
+			logged <- packet.Key
+			return nil
+		},
+	}
+	addr := StartTestServer(t, NewTestServer(proxy.HandleRequest, true))
+	response, err := ExchangePacket(context.Background(), GetTestPacketWithMessageAuthenticator(1, "secret"), addr)
+	if err != nil {
+		t.Fatalf("ExchangePacket failed with: %v", err)
+	}
+	if response != CodeAccountingResponse {
+		t.Errorf("response.Code = %q, want %q", response, CodeAccountingResponse)
+	}
+	<-logged
+	forwarded := <-packets
+	values := make(map[string]string)
+	for _, attr := range forwarded.Attributes {
+		values[attr.Name] = attr.Value
+	}
+	if values["Acct-Session-Id"] != testSessionId {
+		t.Errorf("Acct-Session-Id = %q, want %q", values["Acct-Session-Id"], testSessionId)
+	}
+	if _, ok := values["Proxy-State"]; !ok {
+		t.Error("forwarded request has no Proxy-State")
+	}
+	if _, ok := values["Message-Authenticator"]; !ok {
+		t.Error("forwarded request has no Message-Authenticator")
+	}
+}
+
+func TestProxyFailover(t *testing.T) {
+	silent, received := StartFakeServer(t, func(int, *RequestPacket) []byte { return nil })
+	packets, upstream := StartUpstreamServer(t)
+	proxy := &Proxy{
+		Upstreams: []AccountingServer{{Addr: silent, Secret: "upstream"}, {Addr: upstream, Secret: "upstream"}},
+		Timeout:   200 * time.Millisecond,
+		Retries:   -1,
+	}
+	addr := StartTestServer(t, NewTestServer(proxy.HandleRequest, true))
+	for id := byte(1); id <= 2; id++ {
+		if _, err := ExchangePacket(context.Background(), GetTestPacket(id), addr); err != nil {
+			t.Fatalf("ExchangePacket failed with: %v", err)
+		}
+		<-packets
+	}
+	if got := len(received()); got != 1 {
+		t.Errorf("dead upstream got %d requests, want 1", got)
+	}
+	if dead := proxy.DeadUpstreams(); len(dead) != 1 || dead[0] != silent {
+		t.Errorf("DeadUpstreams() = %v, want [%s]", dead, silent)
+	}
+}
+
+func TestProxyNoUpstream(t *testing.T) {
+	silent, _ := StartFakeServer(t, func(int, *RequestPacket) []byte { return nil })
+	proxy := &Proxy{
+		Upstreams: []AccountingServer{{Addr: silent, Secret: "upstream"}},
+		Timeout:   100 * time.Millisecond,
+		Retries:   -1,
+	}
+	addr := StartTestServer(t, NewTestServer(proxy.HandleRequest, true))
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	if _, err := ExchangePacket(ctx, GetTestPacket(1), addr); err == nil {
+		t.Error("NAS got a response without upstream")
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if _, err := ExchangePacket(ctx, GetStatusServerPacket(2, "secret"), addr); err == nil {
+		t.Error("Status-Server got a response without upstream")
+	}
+}
+
+func TestProxyEncryptedAttributes(t *testing.T) {
+	// the upstream keeps the authenticator check
+	packets, upstream := StartUpstreamServer(t)
+	proxy := &Proxy{Upstreams: []AccountingServer{{Addr: upstream, Secret: "upstream"}}}
+	addr := StartTestServer(t, NewTestServer(proxy.HandleRequest, true))
+
+	b := AppendEncryptedTestAttribute(GetTestPacket(1), 69, 1, "l2tp-secret")
+	var authenticator [16]byte
+	copy(authenticator[:], b[4:20])
+	vsa := []byte{0, 0, 1, 0x37, 16, 0}
+	vsa = append(vsa, encryptSalted([]byte{0xca, 0xfe}, []byte("secret"), authenticator, [2]byte{0x81, 2})...)
+	vsa[5] = byte(len(vsa) - 4)
+	b = append(b, 26, byte(2+len(vsa)))
+	b = append(b, vsa...)
+	binary.BigEndian.PutUint16(b[2:4], uint16(len(b)))
+	SignTestPacket(b, "secret")
+	request, err := ParsePacket(b, []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := ExchangePacket(ctx, b, addr); err != nil {
+		t.Fatalf("ExchangePacket failed with: %v", err)
+	}
+	forwarded := <-packets
+	encrypted := func(attrs []RadiusAttribute) [][]byte {
+		var values [][]byte
+		for _, attr := range attrs {
+			if attr.Type == 69 || attr.Type == typeVendorSpecific {
+				values = append(values, attr.Value)
+			}
+		}
+		return values
+	}
+	want, got := encrypted(request.Attributes), encrypted(forwarded.request.Attributes)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("forwarded encrypted attributes = %x, want them unchanged %x", got, want)
+	}
+}
@@ -1,8 +1,12 @@
 package accter
 
 import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/subtle"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"strconv"
 )
 
@@ -14,6 +18,18 @@ const (
 	// Standard RADIUS ACCOUNTING packet codes.
 	CodeAccountingRequest  Code = 4
 	CodeAccountingResponse Code = 5
+	// CodeStatusServer is used for health checks as described in RFC 5997
+	CodeStatusServer Code = 12
+)
+
+// Attribute types which are used by the server itself.
+const (
+	typeUserName             uint8 = 1
+	typeVendorSpecific       uint8 = 26
+	typeNasIdentifier        uint8 = 32
+	typeProxyState           uint8 = 33
+	typeAcctDelayTime        uint8 = 41
+	typeMessageAuthenticator uint8 = 80
 )
 
 // Code defines the RADIUS packet type.
@@ -89,12 +105,229 @@ func ParseAttributes(b []byte) ([]RadiusAttribute, error) {
 	return attrs, nil
 }
 
+/*
+ * VerifyAuthenticator checks the Request Authenticator of an Accounting-Request
+ * as described in RFC 2866 section 3. The authenticator is the MD5 hash over:
+ *  - code, identifier and length
+ *  - sixteen zero bytes in place of the authenticator
+ *  - the attributes
+ *  - the shared secret
+ */
+func (p *RequestPacket) VerifyAuthenticator() bool {
+	hash := md5.New()
+	header := make([]byte, 20)
+	header[0] = byte(p.Code)
+	header[1] = p.Identifier
+	binary.BigEndian.PutUint16(header[2:4], p.Length)
+	hash.Write(header)
+	for _, attr := range p.Attributes {
+		hash.Write([]byte{attr.Type, attr.Length})
+		hash.Write(attr.Value)
+	}
+	hash.Write(p.Secret)
+	return subtle.ConstantTimeCompare(hash.Sum(nil), p.Authenticator[:]) == 1
+}
+
+/*
+ * VerifyResponseAuthenticator checks the Response Authenticator of a response
+ * to the request with the given Request Authenticator as described in RFC 2866
+ * section 3. A Message-Authenticator is checked too if the response has one.
+ */
+func (p *RequestPacket) VerifyResponseAuthenticator(requestAuthenticator [16]byte) bool {
+	hash := md5.New()
+	header := make([]byte, 4)
+	header[0] = byte(p.Code)
+	header[1] = p.Identifier
+	binary.BigEndian.PutUint16(header[2:4], p.Length)
+	hash.Write(header)
+	hash.Write(requestAuthenticator[:])
+	for _, attr := range p.Attributes {
+		hash.Write([]byte{attr.Type, attr.Length})
+		hash.Write(attr.Value)
+	}
+	hash.Write(p.Secret)
+	if subtle.ConstantTimeCompare(hash.Sum(nil), p.Authenticator[:]) != 1 {
+		return false
+	}
+	if !p.hasMessageAuthenticator() {
+		return true
+	}
+	request := *p
+	request.Authenticator = requestAuthenticator
+	return request.VerifyMessageAuthenticator()
+}
+
+/*
+ * VerifyMessageAuthenticator checks the Message-Authenticator attribute as
+ * described in RFC 3579 section 3.2. The HMAC-MD5 keyed with the secret is
+ * calculated over the packet with the Message-Authenticator set to zero.
+ * Accounting-Requests use sixteen zero bytes instead of the Request
+ * Authenticator since it is calculated afterwards over the whole packet.
+ * Packets without a Message-Authenticator are never valid.
+ */
+func (p *RequestPacket) VerifyMessageAuthenticator() bool {
+	var got []byte
+	for _, attr := range p.Attributes {
+		if attr.Type == typeMessageAuthenticator {
+			got = attr.Value
+		}
+	}
+	if len(got) != md5.Size {
+		return false
+	}
+	mac := hmac.New(md5.New, p.Secret)
+	header := make([]byte, 4)
+	header[0] = byte(p.Code)
+	header[1] = p.Identifier
+	binary.BigEndian.PutUint16(header[2:4], p.Length)
+	mac.Write(header)
+	if p.Code == CodeAccountingRequest {
+		mac.Write(make([]byte, md5.Size))
+	} else {
+		mac.Write(p.Authenticator[:])
+	}
+	for _, attr := range p.Attributes {
+		mac.Write([]byte{attr.Type, attr.Length})
+		if attr.Type == typeMessageAuthenticator {
+			mac.Write(make([]byte, md5.Size))
+		} else {
+			mac.Write(attr.Value)
+		}
+	}
+	return hmac.Equal(mac.Sum(nil), got)
+}
+
+/*
+ * Encode serializes the packet, the Length field is ignored and calculated.
+ * Long-Extended-Type values are split into fragments of RFC 6929 section 2.2.
+ * A Message-Authenticator attribute is calculated as described in RFC 3579
+ * section 3.2, the value in Attributes is only a placeholder of 16 bytes. The
+ * authenticator depends on the code:
+ *  - Accounting-Request: the Request Authenticator of RFC 2866 section 3,
+ *    the MD5 hash over the packet with sixteen zero bytes as authenticator
+ *    and the secret
+ *  - Accounting-Response: Authenticator must hold the Request Authenticator,
+ *    it is replaced by the MD5 hash over the packet and the secret
+ *  - other codes like Status-Server use Authenticator as it is
+ */
+func (p *RequestPacket) Encode() ([]byte, error) {
+	attrs := splitFragments(p.Attributes)
+	length := 20
+	for _, attr := range attrs {
+		if len(attr.Value) > maxAttributeValue {
+			return nil, fmt.Errorf("attribute %d is too long", attr.Type)
+		}
+		if attr.Type == typeMessageAuthenticator && len(attr.Value) != md5.Size {
+			return nil, fmt.Errorf("message authenticator has %d bytes instead of %d", len(attr.Value), md5.Size)
+		}
+		length += 2 + len(attr.Value)
+	}
+	if length > MaxPacketLength {
+		return nil, errors.New("radius packet is too long")
+	}
+	b := make([]byte, length)
+	b[0] = byte(p.Code)
+	b[1] = p.Identifier
+	binary.BigEndian.PutUint16(b[2:4], uint16(length))
+	if p.Code != CodeAccountingRequest {
+		copy(b[4:20], p.Authenticator[:])
+	}
+	offset, ma := 20, 0
+	for _, attr := range attrs {
+		b[offset] = attr.Type
+		b[offset+1] = uint8(2 + len(attr.Value))
+		copy(b[offset+2:], attr.Value)
+		if attr.Type == typeMessageAuthenticator {
+			ma = offset + 2
+			copy(b[ma:ma+md5.Size], make([]byte, md5.Size))
+		}
+		offset += 2 + len(attr.Value)
+	}
+	if ma > 0 {
+		mac := hmac.New(md5.New, p.Secret)
+		mac.Write(b)
+		mac.Sum(b[ma:ma])
+	}
+	if p.Code == CodeAccountingRequest || p.Code == CodeAccountingResponse {
+		hash := md5.New()
+		hash.Write(b)
+		hash.Write(p.Secret)
+		hash.Sum(b[4:4])
+	}
+	return b, nil
+}
+
+// encodeResponse builds the response to request with the given attributes
+func encodeResponse(code Code, request *RequestPacket, attrs []RadiusAttribute) ([]byte, error) {
+	response := &RequestPacket{
+		Code:          code,
+		Identifier:    request.Identifier,
+		Secret:        request.Secret,
+		Authenticator: request.Authenticator,
+		Attributes:    attrs,
+	}
+	return response.Encode()
+}
+
+func newMessageAuthenticator() RadiusAttribute {
+	return RadiusAttribute{Type: typeMessageAuthenticator, Length: 18, Value: make([]byte, md5.Size)}
+}
+
+func (p *RequestPacket) hasMessageAuthenticator() bool {
+	for _, attr := range p.Attributes {
+		if attr.Type == typeMessageAuthenticator {
+			return true
+		}
+	}
+	return false
+}
+
+// setUserName replaces the value of the User-Name, a packet which was not received is ignored
+func (p *RequestPacket) setUserName(name string) error {
+	if p == nil {
+		return nil
+	}
+	for i, attr := range p.Attributes {
+		if attr.Type == typeUserName {
+			userName, err := newRadiusAttribute(typeUserName, []byte(name))
+			if err != nil {
+				return err
+			}
+			p.Attributes[i] = userName
+			return nil
+		}
+	}
+	return nil
+}
+
+// proxyStates returns the Proxy-State attributes which have to be echoed in the response
+func (p *RequestPacket) proxyStates() []RadiusAttribute {
+	var attrs []RadiusAttribute
+	for _, attr := range p.Attributes {
+		if attr.Type == typeProxyState {
+			attrs = append(attrs, attr)
+		}
+	}
+	return attrs
+}
+
+func hasAttribute(p *RequestPacket, t uint8, value string) bool {
+	for _, attr := range p.Attributes {
+		if attr.Type == t && string(attr.Value) == value {
+			return true
+		}
+	}
+	return false
+}
+
 func (c Code) String() string {
 	switch c {
 	case CodeAccountingRequest:
 		return `Accounting-Request`
 	case CodeAccountingResponse:
 		return `Accounting-Response`
+	case CodeStatusServer:
+		return `Status-Server`
 	default:
 		return "Unsupported(" + strconv.Itoa(int(c)) + ")"
 	}
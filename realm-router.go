@@ -0,0 +1,135 @@
+package accter
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// UnknownRealmAction is what the RealmRouter does with requests of realms without route
+type UnknownRealmAction int
+
+const (
+	// RejectUnknownRealm withholds the response and logs the request as failed
+	RejectUnknownRealm UnknownRealmAction = iota
+	// DropUnknownRealm withholds the response silently
+	DropUnknownRealm
+	// AcceptUnknownRealm responds without calling a handler
+	AcceptUnknownRealm
+)
+
+var (
+	// ErrDrop can be returned by handlers to withhold the response without reporting a failure
+	ErrDrop = errors.New("packet dropped")
+	// ErrUnknownRealm is returned for rejected requests of realms without route
+	ErrUnknownRealm = errors.New("unknown realm")
+)
+
+// RealmRoute is where the requests of a realm are handled
+type RealmRoute struct {
+	// Handler defaults to HandleRequest or HandleRequestWithReply of the server
+	Handler func(*JsonPacket) error
+	// StripRealm removes the realm from the User-Name passed to the handler and forwarded by a Proxy
+	StripRealm bool
+}
+
+/*
+ * RealmRouter dispatches requests by the realm of their User-Name. The realm
+ * is found in the forms "user@realm" and "realm\user" unless Regexp is set.
+ * Realms are compared case insensitive. Requests without a route in Routes
+ * are handled by Default, if it is not set UnknownRealm applies to them.
+ */
+type RealmRouter struct {
+	Routes  map[string]RealmRoute
+	Default *RealmRoute
+	// Regexp must have a group named "realm", a group named "user" is used as stripped User-Name
+	Regexp       *regexp.Regexp
+	UnknownRealm UnknownRealmAction
+}
+
+// SplitRealm returns the user and the realm of a User-Name, the realm is empty if there is none
+func (r *RealmRouter) SplitRealm(userName string) (string, string) {
+	if r.Regexp != nil {
+		match := r.Regexp.FindStringSubmatch(userName)
+		if match == nil {
+			return userName, ""
+		}
+		user, realm := userName, ""
+		for i, name := range r.Regexp.SubexpNames() {
+			switch name {
+			case "realm":
+				realm = match[i]
+			case "user":
+				user = match[i]
+			}
+		}
+		return user, realm
+	}
+	if i := strings.LastIndex(userName, "@"); i >= 0 {
+		return userName[:i], userName[i+1:]
+	}
+	if i := strings.Index(userName, `\`); i >= 0 {
+		return userName[i+1:], userName[:i]
+	}
+	return userName, ""
+}
+
+// checkHandlers returns an error if a route has no handler, it is used for servers without handler
+func (r *RealmRouter) checkHandlers() error {
+	for name, route := range r.Routes {
+		if route.Handler == nil {
+			return fmt.Errorf("realm route %q has no handler", name)
+		}
+	}
+	if r.Default != nil && r.Default.Handler == nil {
+		return errors.New("default realm route has no handler")
+	}
+	return nil
+}
+
+/*
+ * route returns the route of the packet and strips the realm if configured,
+ * in the attributes of the handler and of the received request.
+ * Without a route nil is returned for accepted requests, ErrDrop for dropped
+ * and ErrUnknownRealm for rejected ones.
+ */
+func (r *RealmRouter) route(packet *JsonPacket) (*RealmRoute, error) {
+	index := -1
+	for i, attr := range packet.Attributes {
+		if attr.Name == "User-Name" {
+			index = i
+			break
+		}
+	}
+	var user, realm string
+	if index >= 0 {
+		user, realm = r.SplitRealm(packet.Attributes[index].Value)
+	}
+	route := r.Default
+	for name, candidate := range r.Routes {
+		if realm != "" && strings.EqualFold(name, realm) {
+			candidate := candidate
+			route = &candidate
+			break
+		}
+	}
+	if route == nil {
+		switch r.UnknownRealm {
+		case AcceptUnknownRealm:
+			return nil, nil
+		case DropUnknownRealm:
+			return nil, fmt.Errorf("%w: %v %q", ErrDrop, ErrUnknownRealm, realm)
+		default:
+			return nil, fmt.Errorf("%w %q", ErrUnknownRealm, realm)
+		}
+	}
+	if route.StripRealm && realm != "" {
+		packet.Attributes[index].Value = user
+		if err := packet.request.setUserName(user); err != nil {
+			return nil, err
+		}
+	}
+	packet.Realm = realm
+	return route, nil
+}
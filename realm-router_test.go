@@ -0,0 +1,184 @@
+package accter
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// GetRealmTestPacket returns a test packet with the given User-Name
+func GetRealmTestPacket(id byte, userName string) []byte {
+	packet := NewTestPacket(id, "secret")
+	for i, attr := range packet.Attributes {
+		if attr.Type == 1 {
+			packet.Attributes[i], _ = NewAttribute("User-Name", userName)
+		}
+	}
+	b, err := packet.Encode()
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// NewRealmTestServer returns a server which dispatches with the given router
+func NewRealmTestServer(router *RealmRouter, f func(packet *JsonPacket) error) *PacketServer {
+	return &PacketServer{
+		Secret:              "secret",
+		AllowRetransmission: true,
+		HandleRequest:       f,
+		Router:              router,
+	}
+}
+
+func TestSplitRealm(t *testing.T) {
+	tests := []struct {
+		router    *RealmRouter
+		userName  string
+		wantUser  string
+		wantRealm string
+	}{
+		{&RealmRouter{}, "alice@example.com", "alice", "example.com"},
+		{&RealmRouter{}, "alice@home@example.com", "alice@home", "example.com"},
+		{&RealmRouter{}, `EXAMPLE\alice`, "alice", "EXAMPLE"},
+		{&RealmRouter{}, "alice", "alice", ""},
+		{&RealmRouter{Regexp: regexp.MustCompile(`^(?P<user>[^%]+)%(?P<realm>.+)$`)}, "alice%example.com", "alice", "example.com"},
+		{&RealmRouter{Regexp: regexp.MustCompile(`^(?P<realm>[a-z]+)/`)}, "acme/alice", "acme/alice", "acme"},
+		{&RealmRouter{Regexp: regexp.MustCompile(`^(?P<realm>[a-z]+)/`)}, "alice@example.com", "alice@example.com", ""},
+	}
+	for _, test := range tests {
+		user, realm := test.router.SplitRealm(test.userName)
+		if user != test.wantUser || realm != test.wantRealm {
+			t.Errorf("SplitRealm(%q) = %q, %q, want %q, %q", test.userName, user, realm, test.wantUser, test.wantRealm)
+		}
+	}
+}
+
+func TestRealmRouter(t *testing.T) {
+	routed := make(chan *JsonPacket, 1)
+	fallback := make(chan *JsonPacket, 1)
+	router := &RealmRouter{
+		Routes: map[string]RealmRoute{
+			"domain.ch": {
+				Handler: func(packet *JsonPacket) error {
+					routed <- packet
+					return nil
+				},
+				StripRealm: true,
+			},
+			"example.com": {},
+		},
+	}
+	server := NewRealmTestServer(router, func(packet *JsonPacket) error {
+		fallback <- packet
+		return nil
+	})
+	addr := StartTestServer(t, server)
+
+	if _, err := ExchangePacket(context.Background(), GetRealmTestPacket(1, "testuser@DOMAIN.ch"), addr); err != nil {
+		t.Fatalf("ExchangePacket failed with: %v", err)
+	}
+	packet := <-routed
+	if packet.Attributes[0].Value != "testuser" {
+		t.Errorf("User-Name = %q, want %q", packet.Attributes[0].Value, "testuser")
+	}
+	if packet.Realm != "DOMAIN.ch" {
+		t.Errorf("Realm = %q, want %q", packet.Realm, "DOMAIN.ch")
+	}
+
+	if _, err := ExchangePacket(context.Background(), GetRealmTestPacket(2, `example.com\testuser`), addr); err != nil {
+		t.Fatalf("ExchangePacket failed with: %v", err)
+	}
+	packet = <-fallback
+	if packet.Attributes[0].Value != `example.com\testuser` {
+		t.Errorf("User-Name = %q, want the unstripped name", packet.Attributes[0].Value)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	if _, err := ExchangePacket(ctx, GetRealmTestPacket(3, "testuser@unknown.org"), addr); err == nil {
+		t.Error("request of an unknown realm got a response")
+	}
+	if got := server.GetUnknownRealmCount(); got != 1 {
+		t.Errorf("GetUnknownRealmCount() = %d, want 1", got)
+	}
+	if !server.IsHealthy() {
+		t.Error("rejected realm marked the server unhealthy")
+	}
+}
+
+func TestUnknownRealm(t *testing.T) {
+	tests := []struct {
+		action       UnknownRealmAction
+		defaultRoute *RealmRoute
+		wantResponse bool
+		wantHandled  bool
+	}{
+		{RejectUnknownRealm, nil, false, false},
+		{DropUnknownRealm, nil, false, false},
+		{AcceptUnknownRealm, nil, true, false},
+		{RejectUnknownRealm, &RealmRoute{}, true, true},
+	}
+	for _, test := range tests {
+		handled := make(chan *JsonPacket, 1)
+		router := &RealmRouter{
+			Routes:       map[string]RealmRoute{"example.com": {}},
+			Default:      test.defaultRoute,
+			UnknownRealm: test.action,
+		}
+		addr := StartTestServer(t, NewRealmTestServer(router, func(packet *JsonPacket) error {
+			handled <- packet
+			return nil
+		}))
+		ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+		_, err := ExchangePacket(ctx, GetTestPacket(1), addr)
+		cancel()
+		if gotResponse := err == nil; gotResponse != test.wantResponse {
+			t.Errorf("action %d with default %v: got response %t, want %t", test.action, test.defaultRoute, gotResponse, test.wantResponse)
+		}
+		select {
+		case <-handled:
+			if !test.wantHandled {
+				t.Errorf("action %d with default %v: request was handled", test.action, test.defaultRoute)
+			}
+		default:
+			if test.wantHandled {
+				t.Errorf("action %d with default %v: request was not handled", test.action, test.defaultRoute)
+			}
+		}
+	}
+}
+
+func TestRealmRouterProxy(t *testing.T) {
+	packets, upstream := StartUpstreamServer(t)
+	proxy := &Proxy{Upstreams: []AccountingServer{{Addr: upstream, Secret: "upstream"}}}
+	router := &RealmRouter{
+		Routes: map[string]RealmRoute{
+			"domain.ch": {Handler: proxy.HandleRequest, StripRealm: true},
+		},
+	}
+	addr := StartTestServer(t, NewRealmTestServer(router, nil))
+	if _, err := ExchangePacket(context.Background(), GetRealmTestPacket(1, "testuser@domain.ch"), addr); err != nil {
+		t.Fatalf("ExchangePacket failed with: %v", err)
+	}
+	forwarded := <-packets
+	for _, attr := range forwarded.Attributes {
+		if attr.Name == "User-Name" && attr.Value != "testuser" {
+			t.Errorf("forwarded User-Name = %q, want %q", attr.Value, "testuser")
+		}
+	}
+}
+
+func TestRealmRouteWithoutHandler(t *testing.T) {
+	routers := []*RealmRouter{
+		{Routes: map[string]RealmRoute{"example.com": {StripRealm: true}}},
+		{Default: &RealmRoute{}},
+	}
+	for _, router := range routers {
+		server := &PacketServer{Secret: "secret", Router: router}
+		if err := server.Serve(); err == nil {
+			t.Errorf("Serve() with router %v accepted a route without handler", router)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package accter
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultRedisTimeout is the time a command to the Redis server may take
+	DefaultRedisTimeout = time.Second
+	redisMaxIdle        = 16
+)
+
+/*
+ * RedisStore is a KVStore on a Redis server or any server speaking the
+ * Redis protocol (RESP). Connections are kept open and reused.
+ */
+type RedisStore struct {
+	// Addr is the host and port like "redis.example.com:6379"
+	Addr     string
+	Password string
+	DB       int
+	// Timeout per command, defaults to DefaultRedisTimeout
+	Timeout time.Duration
+	mu      sync.Mutex
+	idle    []*redisConn
+}
+
+type redisConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+// redisError is an error reply of the server, the connection is still usable
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+func (s *RedisStore) SetIfAbsent(key string, value []byte, ttl time.Duration) (bool, error) {
+	reply, err := s.do("SET", key, string(value), "PX", redisMilliseconds(ttl), "NX")
+	if err != nil {
+		return false, err
+	}
+	return reply != nil, nil
+}
+
+func (s *RedisStore) Set(key string, value []byte, ttl time.Duration) error {
+	_, err := s.do("SET", key, string(value), "PX", redisMilliseconds(ttl))
+	return err
+}
+
+func (s *RedisStore) Get(key string) ([]byte, error) {
+	reply, err := s.do("GET", key)
+	if err != nil || reply == nil {
+		return nil, err
+	}
+	value, ok := reply.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("redis: unexpected reply %v to GET", reply)
+	}
+	return value, nil
+}
+
+func (s *RedisStore) Delete(key string) error {
+	_, err := s.do("DEL", key)
+	return err
+}
+
+// Close closes the idle connections
+func (s *RedisStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.idle {
+		conn.Close()
+	}
+	s.idle = nil
+	return nil
+}
+
+// do sends the command and returns the reply, nil is returned for null replies
+func (s *RedisStore) do(args ...string) (interface{}, error) {
+	conn, err := s.conn()
+	if err != nil {
+		return nil, err
+	}
+	reply, err := conn.command(s.timeout(), args...)
+	var replyErr redisError
+	if err != nil && !errors.As(err, &replyErr) {
+		conn.Close()
+		return nil, err
+	}
+	s.release(conn)
+	return reply, err
+}
+
+func (s *RedisStore) conn() (*redisConn, error) {
+	s.mu.Lock()
+	if n := len(s.idle); n > 0 {
+		conn := s.idle[n-1]
+		s.idle = s.idle[:n-1]
+		s.mu.Unlock()
+		return conn, nil
+	}
+	s.mu.Unlock()
+	c, err := net.DialTimeout("tcp", s.Addr, s.timeout())
+	if err != nil {
+		return nil, err
+	}
+	conn := &redisConn{Conn: c, reader: bufio.NewReader(c)}
+	if s.Password != "" {
+		if _, err := conn.command(s.timeout(), "AUTH", s.Password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if s.DB != 0 {
+		if _, err := conn.command(s.timeout(), "SELECT", strconv.Itoa(s.DB)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+func (s *RedisStore) release(conn *redisConn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.idle) >= redisMaxIdle {
+		conn.Close()
+		return
+	}
+	s.idle = append(s.idle, conn)
+}
+
+func (s *RedisStore) timeout() time.Duration {
+	if s.Timeout <= 0 {
+		return DefaultRedisTimeout
+	}
+	return s.Timeout
+}
+
+func (c *redisConn) command(timeout time.Duration, args ...string) (interface{}, error) {
+	c.SetDeadline(time.Now().Add(timeout))
+	if _, err := c.Write(encodeRedisCommand(args...)); err != nil {
+		return nil, err
+	}
+	return readRedisReply(c.reader)
+}
+
+// encodeRedisCommand encodes the command as array of bulk strings
+func encodeRedisCommand(args ...string) []byte {
+	b := []byte("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		b = append(b, "$"+strconv.Itoa(len(arg))+"\r\n"...)
+		b = append(b, arg...)
+		b = append(b, "\r\n"...)
+	}
+	return b
+}
+
+/*
+ * readRedisReply reads a RESP reply. Simple strings are returned as string,
+ * integers as int64, bulk strings as []byte and arrays as []interface{}.
+ * Null replies are nil, error replies are returned as redisError.
+ */
+func readRedisReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redis: invalid reply %q", line)
+	}
+	kind, line := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return line, nil
+	case '-':
+		return nil, redisError(line)
+	case ':':
+		return strconv.ParseInt(line, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line)
+		if err != nil || n < -1 {
+			return nil, fmt.Errorf("redis: invalid bulk length %q", line)
+		}
+		if n == -1 {
+			return nil, nil
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line)
+		if err != nil || n < -1 {
+			return nil, fmt.Errorf("redis: invalid array length %q", line)
+		}
+		if n == -1 {
+			return nil, nil
+		}
+		values := make([]interface{}, n)
+		for i := range values {
+			if values[i], err = readRedisReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+	default:
+		return nil, fmt.Errorf("redis: unknown reply type %q", kind)
+	}
+}
+
+func redisMilliseconds(d time.Duration) string {
+	ms := d.Milliseconds()
+	if ms < 1 {
+		ms = 1
+	}
+	return strconv.FormatInt(ms, 10)
+}
@@ -0,0 +1,242 @@
+package accter
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis is an in process server which implements the commands used by RedisStore
+type fakeRedis struct {
+	password string
+	mu       sync.Mutex
+	values   map[string][]byte
+	expires  map[string]time.Time
+	commands []string
+}
+
+// StartFakeRedis starts a fake Redis server, a password requires AUTH before other commands
+func StartFakeRedis(t *testing.T, password string) (string, *fakeRedis) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	f := &fakeRedis{password: password, values: make(map[string][]byte), expires: make(map[string]time.Time)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return listener.Addr().String(), f
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	authenticated := f.password == ""
+	for {
+		request, err := readRedisReply(reader)
+		if err != nil {
+			return
+		}
+		args, ok := request.([]interface{})
+		if !ok || len(args) == 0 {
+			conn.Write([]byte("-ERR protocol error\r\n"))
+			continue
+		}
+		cmd := make([]string, len(args))
+		for i, arg := range args {
+			b, _ := arg.([]byte)
+			cmd[i] = string(b)
+		}
+		name := strings.ToUpper(cmd[0])
+		f.mu.Lock()
+		f.commands = append(f.commands, name)
+		f.mu.Unlock()
+		if name == "AUTH" {
+			if len(cmd) == 2 && cmd[1] == f.password {
+				authenticated = true
+				conn.Write([]byte("+OK\r\n"))
+			} else {
+				conn.Write([]byte("-WRONGPASS invalid password\r\n"))
+			}
+			continue
+		}
+		if !authenticated {
+			conn.Write([]byte("-NOAUTH Authentication required.\r\n"))
+			continue
+		}
+		conn.Write(f.execute(cmd))
+	}
+}
+
+func (f *fakeRedis) execute(cmd []string) []byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for key, expires := range f.expires {
+		if !time.Now().Before(expires) {
+			delete(f.values, key)
+			delete(f.expires, key)
+		}
+	}
+	switch strings.ToUpper(cmd[0]) {
+	case "SELECT":
+		return []byte("+OK\r\n")
+	case "GET":
+		value, ok := f.values[cmd[1]]
+		if !ok {
+			return []byte("$-1\r\n")
+		}
+		return []byte("$" + strconv.Itoa(len(value)) + "\r\n" + string(value) + "\r\n")
+	case "SET":
+		key, value := cmd[1], cmd[2]
+		var ttl time.Duration
+		nx := false
+		for i := 3; i < len(cmd); i++ {
+			switch strings.ToUpper(cmd[i]) {
+			case "NX":
+				nx = true
+			case "PX":
+				ms, _ := strconv.Atoi(cmd[i+1])
+				ttl = time.Duration(ms) * time.Millisecond
+				i++
+			}
+		}
+		if _, ok := f.values[key]; ok && nx {
+			return []byte("$-1\r\n")
+		}
+		f.values[key] = []byte(value)
+		delete(f.expires, key)
+		if ttl > 0 {
+			f.expires[key] = time.Now().Add(ttl)
+		}
+		return []byte("+OK\r\n")
+	case "DEL":
+		_, ok := f.values[cmd[1]]
+		delete(f.values, cmd[1])
+		delete(f.expires, cmd[1])
+		if ok {
+			return []byte(":1\r\n")
+		}
+		return []byte(":0\r\n")
+	default:
+		return []byte("-ERR unknown command '" + cmd[0] + "'\r\n")
+	}
+}
+
+func TestRedisStore(t *testing.T) {
+	addr, fake := StartFakeRedis(t, "pass")
+	store := &RedisStore{Addr: addr, Password: "pass", DB: 2}
+	defer store.Close()
+	if stored, err := store.SetIfAbsent("foo", []byte("bar\r\n"), time.Minute); err != nil || !stored {
+		t.Fatalf("SetIfAbsent() = %t, %v, want true", stored, err)
+	}
+	if stored, err := store.SetIfAbsent("foo", []byte("baz"), time.Minute); err != nil || stored {
+		t.Errorf("SetIfAbsent() of an existing key = %t, %v, want false", stored, err)
+	}
+	if value, err := store.Get("foo"); err != nil || !bytes.Equal(value, []byte("bar\r\n")) {
+		t.Errorf("Get() = %q, %v, want %q", value, err, "bar\r\n")
+	}
+	if err := store.Delete("foo"); err != nil {
+		t.Errorf("Delete failed with: %v", err)
+	}
+	if value, err := store.Get("foo"); err != nil || value != nil {
+		t.Errorf("Get() of a deleted key = %q, %v, want nil", value, err)
+	}
+	store.Set("short", []byte("lived"), 50*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	if value, err := store.Get("short"); err != nil || value != nil {
+		t.Errorf("Get() of an expired key = %q, %v, want nil", value, err)
+	}
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if got := strings.Join(fake.commands[:2], " "); got != "AUTH SELECT" {
+		t.Errorf("connection started with %s, want AUTH SELECT", got)
+	}
+	if got := strings.Count(strings.Join(fake.commands, " "), "AUTH"); got != 1 {
+		t.Errorf("%d connections opened, want 1", got)
+	}
+}
+
+func TestRedisStoreErrors(t *testing.T) {
+	addr, _ := StartFakeRedis(t, "pass")
+	store := &RedisStore{Addr: addr, Password: "wrong"}
+	var replyErr redisError
+	if _, err := store.Get("foo"); !errors.As(err, &replyErr) {
+		t.Errorf("got %v, want an error reply", err)
+	}
+	store = &RedisStore{Addr: addr, Password: "pass"}
+	defer store.Close()
+	if _, err := store.do("FLUSHALL"); !errors.As(err, &replyErr) {
+		t.Errorf("got %v, want an error reply", err)
+	}
+	// the connection is still usable after an error reply
+	if err := store.Set("foo", []byte("bar"), time.Minute); err != nil {
+		t.Errorf("Set failed with: %v", err)
+	}
+	store = &RedisStore{Addr: "127.0.0.1:1", Timeout: 100 * time.Millisecond}
+	if _, err := store.Get("foo"); err == nil {
+		t.Error("Get without server succeeded")
+	}
+}
+
+func TestReadRedisReply(t *testing.T) {
+	tests := []struct {
+		reply string
+		want  interface{}
+	}{
+		{"+OK\r\n", "OK"},
+		{":42\r\n", int64(42)},
+		{"$3\r\nfoo\r\n", []byte("foo")},
+		{"$0\r\n\r\n", []byte{}},
+		{"$-1\r\n", nil},
+		{"*2\r\n$1\r\na\r\n:1\r\n", []interface{}{[]byte("a"), int64(1)}},
+	}
+	for _, test := range tests {
+		got, err := readRedisReply(bufio.NewReader(strings.NewReader(test.reply)))
+		if err != nil {
+			t.Errorf("readRedisReply(%q) failed with: %v", test.reply, err)
+			continue
+		}
+		if !equalRedisReply(got, test.want) {
+			t.Errorf("readRedisReply(%q) = %#v, want %#v", test.reply, got, test.want)
+		}
+	}
+	for _, reply := range []string{"", "OK\r\n", "$5\r\nfoo\r\n", "$x\r\n", "?1\r\n"} {
+		if got, err := readRedisReply(bufio.NewReader(strings.NewReader(reply))); err == nil {
+			t.Errorf("readRedisReply(%q) = %#v, want an error", reply, got)
+		}
+	}
+}
+
+func equalRedisReply(got, want interface{}) bool {
+	switch want := want.(type) {
+	case []byte:
+		got, ok := got.([]byte)
+		return ok && bytes.Equal(got, want)
+	case []interface{}:
+		got, ok := got.([]interface{})
+		if !ok || len(got) != len(want) {
+			return false
+		}
+		for i := range want {
+			if !equalRedisReply(got[i], want[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return got == want
+	}
+}
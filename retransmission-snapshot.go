@@ -0,0 +1,192 @@
+package accter
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// DefaultSnapshotIntervalSeconds is the interval the retransmission cache is saved in
+const DefaultSnapshotIntervalSeconds = 60
+
+// snapshotMagic starts a snapshot file and holds the version of the format
+var snapshotMagic = []byte("ACCTER-RT\x01")
+
+/*
+ * RetransmissionSnapshotter is implemented by retransmission handlers which
+ * can be saved and restored, so requests handled before a restart are still
+ * detected as retransmissions afterwards.
+ */
+type RetransmissionSnapshotter interface {
+	WriteSnapshot(w io.Writer) error
+	// ReadSnapshot returns the number of restored entries
+	ReadSnapshot(r io.Reader) (int, error)
+}
+
+/*
+ * WriteSnapshot writes the completed requests with their expiry. Requests in
+ * flight are left out, if the server stops before they are completed their
+ * retransmissions must reach the handler again.
+ */
+func (r *Retransmissions) WriteSnapshot(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	bw.Write(snapshotMagic)
+	now := time.Now()
+	for i := range r.shards {
+		shard := &r.shards[i]
+		shard.Lock()
+		for e := shard.lru.Back(); e != nil; e = e.Prev() {
+			entry := e.Value.(*retransmission)
+			if !entry.completed || !now.Before(entry.expires) {
+				continue
+			}
+			writeSnapshotEntry(bw, entry)
+		}
+		shard.Unlock()
+	}
+	return bw.Flush()
+}
+
+// writeSnapshotEntry writes the key and response with length prefixes and the expiry in unix nanoseconds
+func writeSnapshotEntry(w *bufio.Writer, entry *retransmission) {
+	var header [10]byte
+	binary.BigEndian.PutUint16(header[0:2], uint16(len(entry.key)))
+	binary.BigEndian.PutUint64(header[2:10], uint64(entry.expires.UnixNano()))
+	w.Write(header[:])
+	w.WriteString(entry.key)
+	binary.BigEndian.PutUint16(header[0:2], uint16(len(entry.response)))
+	w.Write(header[:2])
+	w.Write(entry.response)
+}
+
+/*
+ * ReadSnapshot restores the entries of a snapshot as completed requests,
+ * expired entries are discarded. The entries are restored in the order of
+ * their last use, so the cache evicts the same entries as before.
+ */
+func (r *Retransmissions) ReadSnapshot(rd io.Reader) (int, error) {
+	br := bufio.NewReader(rd)
+	magic := make([]byte, len(snapshotMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != string(snapshotMagic) {
+		return 0, errors.New("not a retransmission snapshot")
+	}
+	restored := 0
+	now := time.Now()
+	for {
+		var header [10]byte
+		if _, err := io.ReadFull(br, header[:]); err != nil {
+			if err == io.EOF {
+				return restored, nil
+			}
+			return restored, fmt.Errorf("truncated snapshot: %v", err)
+		}
+		key := make([]byte, binary.BigEndian.Uint16(header[0:2]))
+		expires := time.Unix(0, int64(binary.BigEndian.Uint64(header[2:10])))
+		var length [2]byte
+		if _, err := io.ReadFull(br, key); err != nil {
+			return restored, fmt.Errorf("truncated snapshot: %v", err)
+		}
+		if _, err := io.ReadFull(br, length[:]); err != nil {
+			return restored, fmt.Errorf("truncated snapshot: %v", err)
+		}
+		response := make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, err := io.ReadFull(br, response); err != nil {
+			return restored, fmt.Errorf("truncated snapshot: %v", err)
+		}
+		if !now.Before(expires) {
+			continue
+		}
+		if len(response) == 0 {
+			response = nil
+		}
+		shard := r.shard(string(key))
+		shard.Lock()
+		r.remove(shard, string(key))
+		entry := r.insertUntil(shard, string(key), expires)
+		entry.completed, entry.response = true, response
+		shard.Unlock()
+		restored++
+	}
+}
+
+// SaveSnapshot writes the snapshot to a temporary file which replaces the file at path
+func SaveSnapshot(s RetransmissionSnapshotter, path string) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := s.WriteSnapshot(f); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// LoadSnapshot restores the snapshot at path, a missing file restores nothing
+func LoadSnapshot(s RetransmissionSnapshotter, path string) (int, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return s.ReadSnapshot(f)
+}
+
+/*
+ * loadSnapshot restores the retransmission cache when the server starts. The
+ * snapshot cycle is started by the caller once the socket is bound.
+ */
+func (s *PacketServer) loadSnapshot() (RetransmissionSnapshotter, error) {
+	snapshotter, ok := s.Retransmission.(RetransmissionSnapshotter)
+	if !ok {
+		return nil, errors.New("retransmission handler does not support snapshots")
+	}
+	n, err := LoadSnapshot(snapshotter, s.SnapshotFile)
+	if err != nil {
+		// a broken snapshot must not keep the server from starting
+		logger.warn("loading retransmission snapshot %s failed: %v", s.SnapshotFile, err)
+	} else {
+		logger.info("restored %d requests from retransmission snapshot %s", n, s.SnapshotFile)
+	}
+	if s.SnapshotIntervalSeconds <= 0 {
+		s.SnapshotIntervalSeconds = DefaultSnapshotIntervalSeconds
+	}
+	return snapshotter, nil
+}
+
+// snapshotCycle saves the retransmission cache periodically until the server shuts down
+func (s *PacketServer) snapshotCycle(snapshotter RetransmissionSnapshotter, shutdown chan bool) {
+	ticker := time.NewTicker(time.Duration(s.SnapshotIntervalSeconds) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-shutdown:
+			return
+		case <-ticker.C:
+			s.saveSnapshot(snapshotter)
+		}
+	}
+}
+
+func (s *PacketServer) saveSnapshot(snapshotter RetransmissionSnapshotter) {
+	s.snapshotMu.Lock()
+	defer s.snapshotMu.Unlock()
+	if err := SaveSnapshot(snapshotter, s.SnapshotFile); err != nil {
+		logger.error("saving retransmission snapshot %s failed: %v", s.SnapshotFile, err)
+		return
+	}
+	logger.debug("saved retransmission snapshot %s", s.SnapshotFile)
+}
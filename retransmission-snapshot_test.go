@@ -0,0 +1,159 @@
+package accter
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSnapshot(t *testing.T) {
+	rt := &Retransmissions{EntryLifetimeSeconds: 300}
+	rt.Begin("completed")
+	rt.Complete("completed", []byte{5, 1})
+	rt.Begin("withheld")
+	rt.Complete("withheld", nil)
+	rt.Begin("in-flight")
+	var snapshot bytes.Buffer
+	if err := rt.WriteSnapshot(&snapshot); err != nil {
+		t.Fatalf("WriteSnapshot failed with: %v", err)
+	}
+	// append an entry which expired after the snapshot was written
+	w := bufio.NewWriter(&snapshot)
+	writeSnapshotEntry(w, &retransmission{key: "expired", expires: time.Now().Add(-time.Second), completed: true})
+	w.Flush()
+
+	restored := &Retransmissions{EntryLifetimeSeconds: 300}
+	n, err := restored.ReadSnapshot(&snapshot)
+	if err != nil {
+		t.Fatalf("ReadSnapshot failed with: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("ReadSnapshot() restored %d entries, want 2", n)
+	}
+	tests := []struct {
+		key          string
+		wantState    RequestState
+		wantResponse []byte
+	}{
+		{"completed", RequestCompleted, []byte{5, 1}},
+		{"withheld", RequestCompleted, nil},
+		{"in-flight", RequestNew, nil},
+		{"expired", RequestNew, nil},
+	}
+	for _, test := range tests {
+		state, response := restored.Begin(test.key)
+		if state != test.wantState || !bytes.Equal(response, test.wantResponse) {
+			t.Errorf("Begin(%q) = %s, %x, want %s, %x", test.key, state, response, test.wantState, test.wantResponse)
+		}
+	}
+}
+
+func TestSnapshotInvalid(t *testing.T) {
+	rt := &Retransmissions{}
+	if _, err := rt.ReadSnapshot(bytes.NewReader([]byte("garbage"))); err == nil {
+		t.Error("ReadSnapshot() accepted a file without magic")
+	}
+	var snapshot bytes.Buffer
+	w := bufio.NewWriter(&snapshot)
+	w.Write(snapshotMagic)
+	writeSnapshotEntry(w, &retransmission{key: "foo", expires: time.Now().Add(time.Minute), completed: true, response: []byte{5, 1}})
+	w.Flush()
+	b := snapshot.Bytes()
+	if n, err := rt.ReadSnapshot(bytes.NewReader(b[:len(b)-1])); err == nil || n != 0 {
+		t.Errorf("ReadSnapshot() of a truncated snapshot = %d, %v, want an error", n, err)
+	}
+	if n, err := LoadSnapshot(rt, filepath.Join(t.TempDir(), "missing")); err != nil || n != 0 {
+		t.Errorf("LoadSnapshot() of a missing file = %d, %v, want 0, nil", n, err)
+	}
+}
+
+func TestSnapshotRestart(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "retransmissions")
+	var calls atomic.Int32
+	handler := func(packet *JsonPacket) error {
+		calls.Add(1)
+		return nil
+	}
+	nas, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nas.Close()
+	testPacket := GetTestPacket(1)
+	var responses [][]byte
+	for i := 0; i < 2; i++ {
+		server := &PacketServer{
+			Secret:        "secret",
+			SnapshotFile:  file,
+			HandleRequest: handler,
+		}
+		StartTestServer(t, server)
+		nas.WriteToUDP(testPacket, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: server.Port})
+		nas.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+		p := make([]byte, MaxPacketLength)
+		n, _, err := nas.ReadFromUDP(p)
+		if err != nil {
+			t.Fatalf("no response from server %d: %v", i, err)
+		}
+		responses = append(responses, p[:n])
+		server.Shutdown()
+		if _, err := os.Stat(file); err != nil {
+			t.Fatalf("no snapshot after Shutdown: %v", err)
+		}
+	}
+	if !bytes.Equal(responses[0], responses[1]) {
+		t.Errorf("replayed response %x differs from %x", responses[1], responses[0])
+	}
+	if got := calls.Load(); got != 1 {
+		t.Errorf("handler called %d times, want 1", got)
+	}
+}
+
+func TestSnapshotInterval(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "retransmissions")
+	server := &PacketServer{
+		Secret:                  "secret",
+		SnapshotFile:            file,
+		SnapshotIntervalSeconds: 1,
+		HandleRequest:           func(*JsonPacket) error { return nil },
+	}
+	addr := StartTestServer(t, server)
+	if _, err := ExchangePacket(context.Background(), GetTestPacket(1), addr); err != nil {
+		t.Fatalf("ExchangePacket failed with: %v", err)
+	}
+	time.Sleep(1500 * time.Millisecond)
+	restored := &Retransmissions{}
+	if n, err := LoadSnapshot(restored, file); err != nil || n != 1 {
+		t.Errorf("LoadSnapshot() = %d, %v, want 1 entry", n, err)
+	}
+}
+
+func TestSnapshotBindFailure(t *testing.T) {
+	taken, err := net.ListenUDP("udp", &net.UDPAddr{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+	file := filepath.Join(t.TempDir(), "retransmissions")
+	server := &PacketServer{
+		Port:                    taken.LocalAddr().(*net.UDPAddr).Port,
+		Secret:                  "secret",
+		SnapshotFile:            file,
+		SnapshotIntervalSeconds: 1,
+		HandleRequest:           func(*JsonPacket) error { return nil },
+	}
+	if err := server.Listen(); err == nil {
+		server.Shutdown()
+		t.Fatal("Listen() on a port in use succeeded")
+	}
+	time.Sleep(1500 * time.Millisecond)
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Errorf("snapshot written by a server which failed to bind: %v", err)
+	}
+}
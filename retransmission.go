@@ -1,11 +1,33 @@
 package accter
 
 import (
+	"container/list"
+	"hash/fnv"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+const (
+	// DefaultMaxRetransmissions is the number of requests the local retransmission handler keeps
+	DefaultMaxRetransmissions = 1 << 20
+	retransmissionShards      = 32
+	wheelSlots                = 64
+)
+
+/*
+ * RetransmissionHandler detects retransmissions by key. A request passes the
+ * states in flight and completed, Begin atomically marks a new request in
+ * flight. Complete keeps the encoded response which is replayed to
+ * retransmissions as described in RFC 5080 section 2.2.2. Fail releases the
+ * key of a request whose handling failed, so its retransmissions reach the
+ * handler again.
+ */
 type RetransmissionHandler interface {
+	// Begin returns the state of the key before the call and the response of completed requests
+	Begin(key string) (RequestState, []byte)
+	Complete(key string, response []byte)
+	Fail(key string)
 	IsRetransmission(key string) bool
 	AddToCache(key string) error
 	RemoveFromCache(key string)
@@ -14,18 +36,82 @@ type RetransmissionHandler interface {
 	SetObjectLifetimeSeconds(sec int)
 }
 
+// RequestState is the state of a request in the RetransmissionHandler
+type RequestState int
+
+const (
+	// RequestNew is a request which is not in the cache, failed requests are new again
+	RequestNew RequestState = iota
+	// RequestInFlight is a request which is still handled
+	RequestInFlight
+	// RequestCompleted is a request which was handled, the response is nil if it was withheld
+	RequestCompleted
+)
+
+func (s RequestState) String() string {
+	switch s {
+	case RequestNew:
+		return "new"
+	case RequestInFlight:
+		return "in flight"
+	case RequestCompleted:
+		return "completed"
+	default:
+		return "unknown"
+	}
+}
+
+/*
+ * Retransmissions is the local RetransmissionHandler. The keys are spread
+ * over shards which each hold an equal part of MaxEntries, the least recently
+ * used entry of a full shard is evicted. Expired entries are removed by a
+ * timing wheel which advances every clean cycle, so a cycle only visits the
+ * entries expiring in it.
+ */
 type Retransmissions struct {
 	sync.RWMutex
-	retransmissions      map[string]time.Time
 	CleanCycleSeconds    int
 	EntryLifetimeSeconds int
+	// MaxEntries defaults to DefaultMaxRetransmissions
+	MaxEntries int
+	shards     [retransmissionShards]retransmissionShard
+	tick       atomic.Uint64
+	hits       atomic.Uint64
+	misses     atomic.Uint64
+	evictions  atomic.Uint64
+	stop       chan struct{}
+	reset      chan struct{}
+}
+
+// RetransmissionStats are the counters of the local retransmission handler
+type RetransmissionStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Size      int
+}
+
+type retransmissionShard struct {
+	sync.Mutex
+	entries map[string]*list.Element
+	// lru holds the entries with the most recently used in front
+	lru   list.List
+	wheel [wheelSlots]map[*retransmission]struct{}
+}
+
+type retransmission struct {
+	key       string
+	expires   time.Time
+	expiresAt uint64
+	completed bool
+	response  []byte
 }
 
 func CreateLocalRetransmissionHandler() RetransmissionHandler {
 	var r = &Retransmissions{
-		retransmissions:      make(map[string]time.Time),
 		EntryLifetimeSeconds: 300,
 		CleanCycleSeconds:    10,
+		MaxEntries:           DefaultMaxRetransmissions,
 	}
 	r.CleanCycle()
 	return r
@@ -35,6 +121,12 @@ func (r *Retransmissions) SetCleanCycleSeconds(sec int) {
 	r.Lock()
 	defer r.Unlock()
 	r.CleanCycleSeconds = sec
+	if r.reset != nil {
+		select {
+		case r.reset <- struct{}{}:
+		default:
+		}
+	}
 }
 
 func (r *Retransmissions) SetObjectLifetimeSeconds(sec int) {
@@ -43,44 +135,239 @@ func (r *Retransmissions) SetObjectLifetimeSeconds(sec int) {
 	r.EntryLifetimeSeconds = sec
 }
 
-func (r *Retransmissions) IsRetransmission(key string) bool {
-	r.RLock()
-	defer r.RUnlock()
-	if _, ok := r.retransmissions[key]; ok {
-		return true
+func (r *Retransmissions) SetMaxEntries(n int) {
+	r.Lock()
+	defer r.Unlock()
+	r.MaxEntries = n
+}
+
+// Stats returns the hits and misses of lookups, the evicted and the current number of entries
+func (r *Retransmissions) Stats() RetransmissionStats {
+	stats := RetransmissionStats{
+		Hits:      r.hits.Load(),
+		Misses:    r.misses.Load(),
+		Evictions: r.evictions.Load(),
+	}
+	for i := range r.shards {
+		shard := &r.shards[i]
+		shard.Lock()
+		stats.Size += len(shard.entries)
+		shard.Unlock()
 	}
-	return false
+	return stats
+}
+
+func (r *Retransmissions) IsRetransmission(key string) bool {
+	shard := r.shard(key)
+	shard.Lock()
+	defer shard.Unlock()
+	return r.lookup(shard, key) != nil
 }
 
 func (r *Retransmissions) AddToCache(key string) error {
+	shard := r.shard(key)
+	shard.Lock()
+	defer shard.Unlock()
+	r.remove(shard, key)
+	r.insert(shard, key)
+	return nil
+}
+
+func (r *Retransmissions) Begin(key string) (RequestState, []byte) {
+	shard := r.shard(key)
+	shard.Lock()
+	defer shard.Unlock()
+	if entry := r.lookup(shard, key); entry != nil {
+		if entry.completed {
+			return RequestCompleted, entry.response
+		}
+		return RequestInFlight, nil
+	}
+	r.insert(shard, key)
+	return RequestNew, nil
+}
+
+// Complete stores the response of a request which is in the cache
+func (r *Retransmissions) Complete(key string, response []byte) {
+	shard := r.shard(key)
+	shard.Lock()
+	defer shard.Unlock()
+	if e, ok := shard.entries[key]; ok {
+		entry := e.Value.(*retransmission)
+		entry.completed = true
+		entry.response = response
+	}
+}
+
+func (r *Retransmissions) Fail(key string) {
+	r.RemoveFromCache(key)
+}
+
+func (r *Retransmissions) RemoveFromCache(key string) {
+	shard := r.shard(key)
+	shard.Lock()
+	defer shard.Unlock()
+	r.remove(shard, key)
+}
+
+/*
+ * CleanCycle starts the goroutine which advances the timing wheel, it does
+ * nothing if the goroutine is already running. Use Stop to end it.
+ */
+func (r *Retransmissions) CleanCycle() error {
 	r.Lock()
 	defer r.Unlock()
-	r.retransmissions[key] = time.Now()
+	if r.stop != nil {
+		return nil
+	}
+	r.stop = make(chan struct{})
+	r.reset = make(chan struct{}, 1)
+	go r.clean(r.stop, r.reset)
 	return nil
 }
 
-func (r *Retransmissions) RemoveFromCache(key string) {
+// Stop ends the goroutine started by CleanCycle, entries still expire on lookup
+func (r *Retransmissions) Stop() {
 	r.Lock()
 	defer r.Unlock()
-	delete(r.retransmissions, key)
+	if r.stop != nil {
+		close(r.stop)
+		r.stop, r.reset = nil, nil
+	}
 }
 
-func (r *Retransmissions) CleanCycle() error {
-	go func() {
-		for {
-			time.Sleep(time.Duration(r.CleanCycleSeconds) * time.Second)
-			r.RLock()
-			rts := make(map[string]time.Time)
-			for k, v := range r.retransmissions {
-				rts[k] = v
+func (r *Retransmissions) clean(stop, reset chan struct{}) {
+	for {
+		timer := time.NewTimer(r.cleanCycle())
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-reset:
+			timer.Stop()
+			continue
+		case <-timer.C:
+		}
+		r.advance()
+	}
+}
+
+// advance moves the timing wheel to the next tick and removes the entries expired in its slot
+func (r *Retransmissions) advance() {
+	tick := r.tick.Add(1)
+	now := time.Now()
+	for i := range r.shards {
+		shard := &r.shards[i]
+		shard.Lock()
+		for entry := range shard.wheel[tick%wheelSlots] {
+			if entry.expiresAt > tick {
+				// the entry expires in a later round of the wheel
+				continue
 			}
-			r.RUnlock()
-			for k := range rts {
-				if time.Since(rts[k]) > time.Duration(r.EntryLifetimeSeconds)*time.Second {
-					r.RemoveFromCache(k)
-				}
+			if now.Before(entry.expires) {
+				r.schedule(shard, entry, tick+1)
+				continue
 			}
+			r.remove(shard, entry.key)
 		}
-	}()
-	return nil
+		shard.Unlock()
+	}
+}
+
+// lookup returns the entry of key and counts the hit or miss, expired entries are removed
+func (r *Retransmissions) lookup(shard *retransmissionShard, key string) *retransmission {
+	e, ok := shard.entries[key]
+	if !ok {
+		r.misses.Add(1)
+		return nil
+	}
+	entry := e.Value.(*retransmission)
+	if !time.Now().Before(entry.expires) {
+		r.remove(shard, key)
+		r.misses.Add(1)
+		return nil
+	}
+	shard.lru.MoveToFront(e)
+	r.hits.Add(1)
+	return entry
+}
+
+// insert adds a new entry for key and evicts the least recently used entries of a full shard
+func (r *Retransmissions) insert(shard *retransmissionShard, key string) *retransmission {
+	lifetime, _, _ := r.config()
+	return r.insertUntil(shard, key, time.Now().Add(lifetime))
+}
+
+func (r *Retransmissions) insertUntil(shard *retransmissionShard, key string, expires time.Time) *retransmission {
+	_, cycle, capacity := r.config()
+	if shard.entries == nil {
+		shard.entries = make(map[string]*list.Element)
+	}
+	for len(shard.entries) >= capacity {
+		r.remove(shard, shard.lru.Back().Value.(*retransmission).key)
+		r.evictions.Add(1)
+	}
+	entry := &retransmission{key: key, expires: expires}
+	shard.entries[key] = shard.lru.PushFront(entry)
+	remaining := time.Until(expires)
+	if remaining < 0 {
+		remaining = 0
+	}
+	// one tick more as the current tick is already partly over
+	r.schedule(shard, entry, r.tick.Load()+uint64((remaining+cycle-1)/cycle)+1)
+	return entry
+}
+
+func (r *Retransmissions) schedule(shard *retransmissionShard, entry *retransmission, tick uint64) {
+	if entry.expiresAt != 0 {
+		delete(shard.wheel[entry.expiresAt%wheelSlots], entry)
+	}
+	entry.expiresAt = tick
+	slot := &shard.wheel[tick%wheelSlots]
+	if *slot == nil {
+		*slot = make(map[*retransmission]struct{})
+	}
+	(*slot)[entry] = struct{}{}
+}
+
+func (r *Retransmissions) remove(shard *retransmissionShard, key string) {
+	e, ok := shard.entries[key]
+	if !ok {
+		return
+	}
+	entry := e.Value.(*retransmission)
+	delete(shard.wheel[entry.expiresAt%wheelSlots], entry)
+	shard.lru.Remove(e)
+	delete(shard.entries, key)
+}
+
+func (r *Retransmissions) shard(key string) *retransmissionShard {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return &r.shards[h.Sum32()%retransmissionShards]
+}
+
+// config returns the entry lifetime, the clean cycle and the capacity of a shard
+func (r *Retransmissions) config() (time.Duration, time.Duration, int) {
+	r.RLock()
+	defer r.RUnlock()
+	capacity := r.MaxEntries
+	if capacity <= 0 {
+		capacity = DefaultMaxRetransmissions
+	}
+	capacity = (capacity + retransmissionShards - 1) / retransmissionShards
+	return time.Duration(r.EntryLifetimeSeconds) * time.Second, r.cleanCycleLocked(), capacity
+}
+
+func (r *Retransmissions) cleanCycle() time.Duration {
+	r.RLock()
+	defer r.RUnlock()
+	return r.cleanCycleLocked()
+}
+
+func (r *Retransmissions) cleanCycleLocked() time.Duration {
+	if r.CleanCycleSeconds <= 0 {
+		return time.Second
+	}
+	return time.Duration(r.CleanCycleSeconds) * time.Second
 }
@@ -1,6 +1,7 @@
 package accter
 
 import (
+	"bytes"
 	"fmt"
 	"sync"
 	"testing"
@@ -49,7 +50,7 @@ func TestAddAndRemove(t *testing.T) {
 	}(&wg)
 	wg.Wait()
 	want := 0
-	got := len(rt.(*Retransmissions).retransmissions)
+	got := rt.(*Retransmissions).Stats().Size
 	if got != want {
 		t.Errorf("got %d, want %d", got, want)
 	}
@@ -64,8 +65,131 @@ func TestCleanCycle(t *testing.T) {
 	}
 	time.Sleep(5 * time.Second)
 	want := 0
-	got := len(rt.(*Retransmissions).retransmissions)
+	got := rt.(*Retransmissions).Stats().Size
 	if got != want {
 		t.Errorf("got %d, want %d", got, want)
 	}
 }
+
+func TestRequestLifecycle(t *testing.T) {
+	rt := CreateLocalRetransmissionHandler()
+	rt.Complete("missing", []byte{5})
+	if rt.IsRetransmission("missing") {
+		t.Error("Complete added a key which was not in the cache")
+	}
+	steps := []struct {
+		action       func()
+		wantState    RequestState
+		wantResponse []byte
+	}{
+		{func() {}, RequestNew, nil},
+		{func() {}, RequestInFlight, nil},
+		{func() { rt.Fail("foo") }, RequestNew, nil},
+		{func() { rt.Complete("foo", []byte{5, 1}) }, RequestCompleted, []byte{5, 1}},
+		{func() { rt.Fail("foo") }, RequestNew, nil},
+		{func() { rt.Complete("foo", nil) }, RequestCompleted, nil},
+	}
+	for i, step := range steps {
+		step.action()
+		state, response := rt.Begin("foo")
+		if state != step.wantState || !bytes.Equal(response, step.wantResponse) {
+			t.Errorf("step %d: Begin() = %s, %x, want %s, %x", i, state, response, step.wantState, step.wantResponse)
+		}
+	}
+}
+
+func TestConcurrentBegin(t *testing.T) {
+	rt := CreateLocalRetransmissionHandler()
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	started := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if state, _ := rt.Begin("foo"); state == RequestNew {
+				mu.Lock()
+				started++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if started != 1 {
+		t.Errorf("%d requests started, want 1", started)
+	}
+}
+
+func TestEviction(t *testing.T) {
+	rt := &Retransmissions{EntryLifetimeSeconds: 300, MaxEntries: retransmissionShards * 2}
+	for i := 0; i < 1000; i++ {
+		rt.AddToCache(fmt.Sprintf("foo%d", i))
+		// keep the first key in use
+		rt.IsRetransmission("foo0")
+	}
+	stats := rt.Stats()
+	if stats.Size > rt.MaxEntries {
+		t.Errorf("Size = %d, want at most %d", stats.Size, rt.MaxEntries)
+	}
+	if stats.Evictions != uint64(1000-stats.Size) {
+		t.Errorf("Evictions = %d, want %d", stats.Evictions, 1000-stats.Size)
+	}
+	if !rt.IsRetransmission("foo0") {
+		t.Error("recently used key was evicted")
+	}
+	if !rt.IsRetransmission("foo999") {
+		t.Error("newest key was evicted")
+	}
+}
+
+func TestStats(t *testing.T) {
+	rt := &Retransmissions{EntryLifetimeSeconds: 300}
+	rt.Begin("foo")
+	rt.Begin("foo")
+	rt.IsRetransmission("bar")
+	rt.AddToCache("bar")
+	want := RetransmissionStats{Hits: 1, Misses: 2, Size: 2}
+	if got := rt.Stats(); got != want {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	rt := &Retransmissions{EntryLifetimeSeconds: 1, CleanCycleSeconds: 1}
+	rt.AddToCache("foo")
+	// the lifetime applies to lookups without a clean cycle
+	time.Sleep(1100 * time.Millisecond)
+	if rt.IsRetransmission("foo") {
+		t.Error("expired key found")
+	}
+	rt.AddToCache("bar")
+	for i := 0; i < 3; i++ {
+		rt.advance()
+	}
+	if got := rt.Stats().Size; got != 1 {
+		t.Errorf("Size = %d before the expiry, want 1", got)
+	}
+	time.Sleep(1100 * time.Millisecond)
+	rt.advance()
+	if got := rt.Stats().Size; got != 0 {
+		t.Errorf("Size = %d after the expiry, want 0", got)
+	}
+}
+
+func TestStopCleanCycle(t *testing.T) {
+	rt := CreateLocalRetransmissionHandler().(*Retransmissions)
+	rt.SetCleanCycleSeconds(1)
+	rt.Stop()
+	rt.Stop()
+	tick := rt.tick.Load()
+	time.Sleep(1500 * time.Millisecond)
+	if got := rt.tick.Load(); got != tick {
+		t.Errorf("wheel advanced %d ticks after Stop", got-tick)
+	}
+	rt.CleanCycle()
+	defer rt.Stop()
+	time.Sleep(1500 * time.Millisecond)
+	if got := rt.tick.Load(); got == tick {
+		t.Error("wheel did not advance after CleanCycle")
+	}
+}
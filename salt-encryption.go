@@ -0,0 +1,78 @@
+package accter
+
+import (
+	"crypto/md5"
+	"errors"
+)
+
+/*
+ * DecryptSalted decrypts a salt encrypted value as described in RFC 2868
+ * section 3.5 and RFC 2548 section 2.4.2. While:
+ *  - b[0:2] is the salt, the most significant bit of b[0] is always set
+ *  - b[2:rest] is the cipher text in blocks of 16 bytes
+ * The first block is decrypted with MD5(secret + authenticator + salt), every
+ * further block with MD5(secret + previous cipher block). The plain text starts
+ * with its length and is padded with zeros.
+ */
+func DecryptSalted(b, secret []byte, authenticator [16]byte) ([]byte, error) {
+	if len(b) < 2+md5.Size || (len(b)-2)%md5.Size != 0 {
+		return nil, errors.New("invalid salt encrypted value length")
+	}
+	if b[0]&0x80 == 0 {
+		return nil, errors.New("invalid salt")
+	}
+	cipher := b[2:]
+	plain := make([]byte, len(cipher))
+	hash := md5.New()
+	hash.Write(secret)
+	hash.Write(authenticator[:])
+	hash.Write(b[0:2])
+	for i := 0; i < len(cipher); i += md5.Size {
+		block := hash.Sum(nil)
+		for j := range block {
+			plain[i+j] = cipher[i+j] ^ block[j]
+		}
+		hash.Reset()
+		hash.Write(secret)
+		hash.Write(cipher[i : i+md5.Size])
+	}
+	length := int(plain[0])
+	if length > len(plain)-1 {
+		return nil, errors.New("invalid salt encrypted value: wrong length")
+	}
+	return plain[1 : 1+length], nil
+}
+
+// encryptSalted is the reverse of DecryptSalted
+func encryptSalted(plain, secret []byte, authenticator [16]byte, salt [2]byte) []byte {
+	padded := make([]byte, (len(plain)+md5.Size)/md5.Size*md5.Size)
+	padded[0] = byte(len(plain))
+	copy(padded[1:], plain)
+	b := make([]byte, 2+len(padded))
+	b[0], b[1] = salt[0]|0x80, salt[1]
+	hash := md5.New()
+	hash.Write(secret)
+	hash.Write(authenticator[:])
+	hash.Write(b[0:2])
+	for i := 0; i < len(padded); i += md5.Size {
+		block := hash.Sum(nil)
+		for j := range block {
+			b[2+i+j] = padded[i+j] ^ block[j]
+		}
+		hash.Reset()
+		hash.Write(secret)
+		hash.Write(b[2+i : 2+i+md5.Size])
+	}
+	return b
+}
+
+// decrypt replaces the value of a salt encrypted attribute with its plain text
+func (r *resolvedAttribute) decrypt(p *RequestPacket) error {
+	plain, err := DecryptSalted(r.value, p.Secret, p.Authenticator)
+	if err != nil {
+		return err
+	}
+	r.value = plain
+	r.decrypted = true
+	return nil
+}
@@ -0,0 +1,111 @@
+package accter
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"testing"
+)
+
+func TestDecryptSalted(t *testing.T) {
+	authenticator := [16]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	for _, plain := range []string{"", "password", "exactly-15-byte", "a password which needs three blocks"} {
+		b := encryptSalted([]byte(plain), []byte("secret"), authenticator, [2]byte{0x12, 0x34})
+		if b[0] != 0x92 || (len(b)-2)%16 != 0 {
+			t.Errorf("got salt %x and length %d", b[0:2], len(b))
+		}
+		got, err := DecryptSalted(b, []byte("secret"), authenticator)
+		if err != nil {
+			t.Fatalf("DecryptSalted failed with: %v", err)
+		}
+		if string(got) != plain {
+			t.Errorf("got %q, want %q", got, plain)
+		}
+	}
+}
+
+func TestDecryptSaltedFail(t *testing.T) {
+	var authenticator [16]byte
+	b := encryptSalted([]byte("password"), []byte("secret"), authenticator, [2]byte{0x80, 1})
+	if _, err := DecryptSalted(b[:len(b)-1], []byte("secret"), authenticator); err == nil {
+		t.Error("wrong length not detected")
+	}
+	b[0] = 0x01
+	if _, err := DecryptSalted(b, []byte("secret"), authenticator); err == nil {
+		t.Error("invalid salt not detected")
+	}
+}
+
+func TestDecryptMPPEKey(t *testing.T) {
+	packet := &RequestPacket{Secret: []byte("secret"), Authenticator: [16]byte{0xaa}}
+	key := bytes.Repeat([]byte{0x5a}, 32)
+	vsa := []byte{0, 0, 1, 0x37, 16, 0}
+	vsa = append(vsa, encryptSalted(key, packet.Secret, packet.Authenticator, [2]byte{0x81, 2})...)
+	vsa[5] = byte(len(vsa) - 4)
+	resolved := resolveAttribute(RadiusAttribute{Type: 26, Value: vsa})
+	if len(resolved) != 1 || !resolved[0].helper.Encrypted {
+		t.Fatalf("got %v, want an encrypted MS-MPPE-Send-Key", resolved)
+	}
+	if err := resolved[0].decrypt(packet); err != nil {
+		t.Fatalf("decrypt failed with: %v", err)
+	}
+	if !bytes.Equal(resolved[0].value, key) || !resolved[0].decrypted {
+		t.Errorf("got %x, want %x", resolved[0].value, key)
+	}
+}
+
+// AppendEncryptedTestAttribute appends an attribute encrypted with the current
+// Request Authenticator, the packet is not signed again.
+func AppendEncryptedTestAttribute(b []byte, t, tag uint8, plain string) []byte {
+	var authenticator [16]byte
+	copy(authenticator[:], b[4:20])
+	v := append([]byte{tag}, encryptSalted([]byte(plain), []byte("secret"), authenticator, [2]byte{0x80, 7})...)
+	b = append(b, t, uint8(2+len(v)))
+	b = append(b, v...)
+	binary.BigEndian.PutUint16(b[2:4], uint16(len(b)))
+	return b
+}
+
+func TestDecryptAttributes(t *testing.T) {
+	got := make(chan JsonAttribute, 1)
+	handler := func(packet *JsonPacket) error {
+		for _, attr := range packet.Attributes {
+			if attr.Name == "Tunnel-Password" {
+				got <- attr
+			}
+		}
+		return nil
+	}
+	tests := []struct {
+		decrypt []string
+		want    string
+	}{
+		{[]string{"Tunnel-Password"}, "l2tp-secret"},
+		{nil, ""},
+	}
+	for _, test := range tests {
+		// the Request Authenticator covers the encrypted attribute, it can not be valid
+		server := &PacketServer{
+			Secret:                 "secret",
+			HandleRequest:          handler,
+			SkipAuthenticatorCheck: true,
+			DecryptAttributes:      test.decrypt,
+		}
+		addr := StartTestServer(t, server)
+		b := AppendEncryptedTestAttribute(GetTestPacket(1), 69, 1, "l2tp-secret")
+		if _, err := ExchangePacket(context.Background(), b, addr); err != nil {
+			t.Fatalf("ExchangePacket failed with: %v", err)
+		}
+		attr := <-got
+		if attr.Tag != 1 {
+			t.Errorf("got tag %d, want 1", attr.Tag)
+		}
+		if test.want != "" && attr.Value != test.want {
+			t.Errorf("got %q, want %q", attr.Value, test.want)
+		}
+		if test.want == "" && attr.Value == "l2tp-secret" {
+			t.Error("attribute decrypted without opt-in")
+		}
+		server.Shutdown()
+	}
+}
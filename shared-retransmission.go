@@ -0,0 +1,151 @@
+package accter
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+ * KVStore is a key value store which is shared by several accter instances.
+ * Values expire after their ttl. SetIfAbsent must be atomic, only one of
+ * concurrent callers stores its value.
+ */
+type KVStore interface {
+	// SetIfAbsent stores the value unless the key exists and reports whether it was stored
+	SetIfAbsent(key string, value []byte, ttl time.Duration) (bool, error)
+	Set(key string, value []byte, ttl time.Duration) error
+	// Get returns nil if the key does not exist
+	Get(key string) ([]byte, error)
+	Delete(key string) error
+}
+
+const (
+	sharedInFlight  byte = 0
+	sharedCompleted byte = 1
+	// DefaultInFlightTTL is how long a request is in flight in the store before another instance may handle it
+	DefaultInFlightTTL = 10 * time.Second
+)
+
+/*
+ * SharedRetransmissions is a RetransmissionHandler which keeps its state in
+ * a KVStore, so a retransmission is detected by every instance using the
+ * same store. Requests are handled if the store fails, a request processed
+ * twice is better than a lost one. The entries expire by the ttl of the
+ * store, CleanCycle has nothing to do. Requests in flight expire after
+ * InFlightTTL, so the retransmissions of a request whose instance crashed
+ * are handled by another one, completed requests are kept for the entry
+ * lifetime.
+ */
+type SharedRetransmissions struct {
+	Store KVStore
+	// Prefix is prepended to the keys in the store
+	Prefix string
+	// InFlightTTL should cover the time the handler takes, defaults to DefaultInFlightTTL
+	InFlightTTL time.Duration
+	mu          sync.RWMutex
+	lifetime    time.Duration
+	storeErrors atomic.Uint64
+}
+
+// NewSharedRetransmissionHandler returns a handler with the entry lifetime of the local handler
+func NewSharedRetransmissionHandler(store KVStore) *SharedRetransmissions {
+	return &SharedRetransmissions{
+		Store:    store,
+		Prefix:   "accter:",
+		lifetime: 300 * time.Second,
+	}
+}
+
+func (r *SharedRetransmissions) Begin(key string) (RequestState, []byte) {
+	// the entry can expire or fail between both calls, so try twice
+	for i := 0; i < 2; i++ {
+		stored, err := r.Store.SetIfAbsent(r.Prefix+key, []byte{sharedInFlight}, r.inFlightTTL())
+		if err != nil {
+			r.storeErrors.Add(1)
+			return RequestNew, nil
+		}
+		if stored {
+			return RequestNew, nil
+		}
+		value, err := r.Store.Get(r.Prefix + key)
+		if err != nil {
+			r.storeErrors.Add(1)
+			return RequestNew, nil
+		}
+		if len(value) == 0 {
+			continue
+		}
+		if value[0] != sharedCompleted {
+			return RequestInFlight, nil
+		}
+		if len(value) == 1 {
+			return RequestCompleted, nil
+		}
+		return RequestCompleted, value[1:]
+	}
+	return RequestNew, nil
+}
+
+func (r *SharedRetransmissions) Complete(key string, response []byte) {
+	value := append([]byte{sharedCompleted}, response...)
+	if err := r.Store.Set(r.Prefix+key, value, r.ttl()); err != nil {
+		r.storeErrors.Add(1)
+	}
+}
+
+func (r *SharedRetransmissions) Fail(key string) {
+	r.RemoveFromCache(key)
+}
+
+func (r *SharedRetransmissions) IsRetransmission(key string) bool {
+	value, err := r.Store.Get(r.Prefix + key)
+	if err != nil {
+		r.storeErrors.Add(1)
+		return false
+	}
+	return value != nil
+}
+
+func (r *SharedRetransmissions) AddToCache(key string) error {
+	return r.Store.Set(r.Prefix+key, []byte{sharedInFlight}, r.inFlightTTL())
+}
+
+func (r *SharedRetransmissions) RemoveFromCache(key string) {
+	if err := r.Store.Delete(r.Prefix + key); err != nil {
+		r.storeErrors.Add(1)
+	}
+}
+
+func (r *SharedRetransmissions) CleanCycle() error {
+	return nil
+}
+
+func (r *SharedRetransmissions) SetCleanCycleSeconds(sec int) {}
+
+func (r *SharedRetransmissions) SetObjectLifetimeSeconds(sec int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lifetime = time.Duration(sec) * time.Second
+}
+
+// GetStoreErrorCount returns the number of failed store operations
+func (r *SharedRetransmissions) GetStoreErrorCount() uint64 {
+	return r.storeErrors.Load()
+}
+
+func (r *SharedRetransmissions) ttl() time.Duration {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.lifetime <= 0 {
+		return 300 * time.Second
+	}
+	return r.lifetime
+}
+
+func (r *SharedRetransmissions) inFlightTTL() time.Duration {
+	if r.InFlightTTL <= 0 {
+		return DefaultInFlightTTL
+	}
+	return r.InFlightTTL
+}
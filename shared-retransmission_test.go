@@ -0,0 +1,119 @@
+package accter
+
+import (
+	"bytes"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSharedRetransmissions(t *testing.T) {
+	addr, _ := StartFakeRedis(t, "")
+	redis := &RedisStore{Addr: addr}
+	defer redis.Close()
+	peers, _ := StartPeerStores(t, "secret", "secret")
+	for name, store := range map[string]KVStore{"redis": redis, "peer": peers} {
+		rt := NewSharedRetransmissionHandler(store)
+		steps := []struct {
+			action       func()
+			wantState    RequestState
+			wantResponse []byte
+		}{
+			{func() {}, RequestNew, nil},
+			{func() {}, RequestInFlight, nil},
+			{func() { rt.Fail("foo") }, RequestNew, nil},
+			{func() { rt.Complete("foo", []byte{5, 1}) }, RequestCompleted, []byte{5, 1}},
+			{func() { rt.Fail("foo") }, RequestNew, nil},
+			{func() { rt.Complete("foo", nil) }, RequestCompleted, nil},
+		}
+		for i, step := range steps {
+			step.action()
+			state, response := rt.Begin("foo")
+			if state != step.wantState || !bytes.Equal(response, step.wantResponse) {
+				t.Errorf("%s step %d: Begin() = %s, %x, want %s, %x", name, i, state, response, step.wantState, step.wantResponse)
+			}
+		}
+		if got := rt.GetStoreErrorCount(); got != 0 {
+			t.Errorf("%s: GetStoreErrorCount() = %d, want 0", name, got)
+		}
+	}
+}
+
+func TestSharedRetransmissionsInFlightExpires(t *testing.T) {
+	addr, _ := StartFakeRedis(t, "")
+	redis := &RedisStore{Addr: addr}
+	defer redis.Close()
+	rt := NewSharedRetransmissionHandler(redis)
+	rt.InFlightTTL = 200 * time.Millisecond
+	rt.Begin("crashed")
+	rt.Begin("completed")
+	rt.Complete("completed", []byte{5, 1})
+	if state, _ := rt.Begin("crashed"); state != RequestInFlight {
+		t.Errorf("Begin() = %s, want %s", state, RequestInFlight)
+	}
+	time.Sleep(300 * time.Millisecond)
+	// the instance handling the request is gone, the retransmission is handled again
+	if state, _ := rt.Begin("crashed"); state != RequestNew {
+		t.Errorf("Begin() after InFlightTTL = %s, want %s", state, RequestNew)
+	}
+	if state, response := rt.Begin("completed"); state != RequestCompleted || !bytes.Equal(response, []byte{5, 1}) {
+		t.Errorf("Begin() of a completed request after InFlightTTL = %s, %x, want %s, 0501", state, response, RequestCompleted)
+	}
+}
+
+func TestSharedRetransmissionsStoreDown(t *testing.T) {
+	rt := NewSharedRetransmissionHandler(&RedisStore{Addr: "127.0.0.1:1", Timeout: 100 * time.Millisecond})
+	for i := 0; i < 2; i++ {
+		if state, _ := rt.Begin("foo"); state != RequestNew {
+			t.Errorf("Begin() = %s without store, want %s", state, RequestNew)
+		}
+	}
+	if got := rt.GetStoreErrorCount(); got != 2 {
+		t.Errorf("GetStoreErrorCount() = %d, want 2", got)
+	}
+}
+
+func TestSharedRetransmissionReplicas(t *testing.T) {
+	storeA, storeB := StartPeerStores(t, "secret", "secret")
+	var calls atomic.Int32
+	var replicas []*net.UDPAddr
+	for _, store := range []KVStore{storeA, storeB} {
+		server := &PacketServer{
+			Secret:         "secret",
+			Retransmission: NewSharedRetransmissionHandler(store),
+			HandleRequest: func(packet *JsonPacket) error {
+				calls.Add(1)
+				return nil
+			},
+		}
+		StartTestServer(t, server)
+		replicas = append(replicas, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: server.Port})
+	}
+	// the NAS sends from one source port, the retransmission lands on the other replica
+	nas, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nas.Close()
+	testPacket := GetTestPacket(1)
+	var responses [][]byte
+	for _, replica := range replicas {
+		nas.WriteToUDP(testPacket, replica)
+		nas.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+		p := make([]byte, MaxPacketLength)
+		n, _, err := nas.ReadFromUDP(p)
+		if err != nil {
+			t.Fatalf("no response from %s: %v", replica, err)
+		}
+		responses = append(responses, p[:n])
+		// give the update time to reach the other replica
+		time.Sleep(100 * time.Millisecond)
+	}
+	if !bytes.Equal(responses[0], responses[1]) {
+		t.Errorf("replayed response %x differs from %x", responses[1], responses[0])
+	}
+	if got := calls.Load(); got != 1 {
+		t.Errorf("handler called %d times, want 1", got)
+	}
+}
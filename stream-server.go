@@ -0,0 +1,188 @@
+package accter
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+type streamConns struct {
+	mu    sync.Mutex
+	conns map[net.Conn]bool
+}
+
+func (c *streamConns) add(conn net.Conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conns == nil {
+		c.conns = make(map[net.Conn]bool)
+	}
+	c.conns[conn] = true
+}
+
+func (c *streamConns) remove(conn net.Conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.conns, conn)
+}
+
+// stopReading unblocks all pending reads, responses can still be written
+func (c *streamConns) stopReading() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for conn := range c.conns {
+		conn.SetReadDeadline(time.Now())
+	}
+}
+
+func (s *PacketServer) listenTCP() (net.Listener, error) {
+	logger.info("starting tcp server on :%d", s.Port)
+	ln, err := net.Listen(NETWORK_TYPE_TCP, fmt.Sprintf(":%d", s.Port))
+	if err != nil {
+		return nil, fmt.Errorf("listen to TCP failed with: %v", err)
+	}
+	return ln, nil
+}
+
+func (s *PacketServer) listenTLS() (net.Listener, error) {
+	if s.TLSConfig == nil {
+		return nil, errors.New("server has no tls config")
+	}
+	config := s.TLSConfig.Clone()
+	if config.ClientCAs != nil && config.ClientAuth == tls.NoClientCert {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	logger.info("starting radsec server on :%d", s.Port)
+	ln, err := tls.Listen(NETWORK_TYPE_TCP, fmt.Sprintf(":%d", s.Port), config)
+	if err != nil {
+		return nil, fmt.Errorf("listen to TLS failed with: %v", err)
+	}
+	return ln, nil
+}
+
+func (s *PacketServer) serveStream(ln net.Listener) error {
+	defer ln.Close()
+	for {
+		conn, err := ln.Accept()
+		select {
+		case <-s.shutdown:
+			logger.info("shutdown signal received, shutting down")
+			s.waitForRoutines()
+			return nil
+		default:
+			if err != nil {
+				logger.error("error accepting connection: %v", err)
+				continue
+			}
+			go s.handleStream(conn)
+		}
+	}
+}
+
+func (s *PacketServer) handleStream(conn net.Conn) {
+	s.streams.add(conn)
+	defer s.streams.remove(conn)
+	defer conn.Close()
+	remoteAddr := conn.RemoteAddr()
+	idleTimeout := time.Duration(s.IdleTimeoutSeconds) * time.Second
+	conn.SetDeadline(time.Now().Add(idleTimeout))
+	client, err := s.lookupStreamClient(conn)
+	if err != nil {
+		logger.warn("rejecting connection: %v", err)
+		return
+	}
+	logger.debug("new connection from: %s", remoteAddr)
+	var (
+		wg      sync.WaitGroup
+		writeMu sync.Mutex
+	)
+	// the responses of running routines are still sent before the connection is closed
+	defer wg.Wait()
+	for {
+		conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		// checked after setting the deadline, else a shutdown could be missed
+		select {
+		case <-s.shutdown:
+			return
+		default:
+		}
+		b, err := readStreamPacket(conn)
+		if err != nil {
+			if err != io.EOF {
+				logger.debug("closing connection from [%s]: %v", remoteAddr, err)
+			}
+			return
+		}
+		s.IncrementRoutineCount()
+		wg.Add(1)
+		go func(b []byte) {
+			defer s.DecreaseRoutineCount()
+			defer wg.Done()
+			res, err := s.handlePacket(b, source{addr: remoteAddr, client: client, stream: true})
+			if err != nil {
+				logger.warn("processing packet faild with: %v", err)
+				return
+			}
+			if res == nil {
+				return
+			}
+			writeMu.Lock()
+			defer writeMu.Unlock()
+			conn.SetWriteDeadline(time.Now().Add(idleTimeout))
+			if _, err := conn.Write(res); err != nil {
+				logger.error("error sending response to [%s]: %v", remoteAddr, err)
+			}
+		}(b)
+	}
+}
+
+/*
+ * lookupStreamClient returns the client of a connection. RadSec clients are
+ * identified by their certificate before falling back to the source address.
+ */
+func (s *PacketServer) lookupStreamClient(conn net.Conn) (*Client, error) {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return s.lookupClient(conn.RemoteAddr())
+	}
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, fmt.Errorf("tls handshake with [%s] failed: %v", conn.RemoteAddr(), err)
+	}
+	certs := tlsConn.ConnectionState().PeerCertificates
+	if s.Clients == nil {
+		client := &Client{Secret: RadSecSecret}
+		if len(certs) > 0 {
+			client.Name = certs[0].Subject.CommonName
+		}
+		return client, nil
+	}
+	if len(certs) > 0 {
+		if client := s.Clients.LookupCertificate(certs[0]); client != nil {
+			return client, nil
+		}
+	}
+	return s.lookupClient(conn.RemoteAddr())
+}
+
+// readStreamPacket reads one packet framed by the length field of the RADIUS header
+func readStreamPacket(r io.Reader) ([]byte, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	length := int(binary.BigEndian.Uint16(header[2:4]))
+	if length < 20 || length > MaxPacketLength {
+		return nil, fmt.Errorf("radius invalid packet length %d", length)
+	}
+	b := make([]byte, length)
+	copy(b, header)
+	if _, err := io.ReadFull(r, b[4:]); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
@@ -0,0 +1,249 @@
+package accter
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testPKI struct {
+	pool   *x509.CertPool
+	caCert *x509.Certificate
+	caKey  *ecdsa.PrivateKey
+}
+
+func NewTestPKI(t *testing.T) *testPKI {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "accter test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testPKI{pool: pool, caCert: cert, caKey: key}
+}
+
+func (p *testPKI) Certificate(t *testing.T, name string, usage x509.ExtKeyUsage) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("::1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, p.caCert, &key.PublicKey, p.caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func StartRadSecServer(t *testing.T, pki *testPKI, f func(packet *JsonPacket) error, clients *ClientTable) string {
+	server := &PacketServer{
+		Network: NETWORK_TYPE_TLS,
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{pki.Certificate(t, "localhost", x509.ExtKeyUsageServerAuth)},
+			ClientCAs:    pki.pool,
+		},
+		Clients:       clients,
+		HandleRequest: f,
+	}
+	return StartTestServer(t, server)
+}
+
+func ExchangeStreamPacket(conn net.Conn, b []byte) ([]byte, error) {
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Write(b); err != nil {
+		return nil, err
+	}
+	return readStreamPacket(conn)
+}
+
+func TestRadSecRequest(t *testing.T) {
+	pki := NewTestPKI(t)
+	var gotClient atomic.Value
+	handler := func(packet *JsonPacket) error {
+		gotClient.Store(packet.Client)
+		return nil
+	}
+	addr := StartRadSecServer(t, pki, handler, nil)
+	conn, err := tls.Dial("tcp", addr, &tls.Config{
+		RootCAs:      pki.pool,
+		ServerName:   "localhost",
+		Certificates: []tls.Certificate{pki.Certificate(t, "nas-1", x509.ExtKeyUsageClientAuth)},
+	})
+	if err != nil {
+		t.Fatalf("dial failed with: %v", err)
+	}
+	defer conn.Close()
+	for _, id := range []byte{1, 2} {
+		testPacket := GetTestPacket(id)
+		SignTestPacket(testPacket, RadSecSecret)
+		res, err := ExchangeStreamPacket(conn, testPacket)
+		if err != nil {
+			t.Fatalf("exchange failed with: %v", err)
+		}
+		if Code(res[0]) != CodeAccountingResponse || res[1] != id {
+			t.Errorf("response = %q id %d, want %q id %d", Code(res[0]), res[1], CodeAccountingResponse, id)
+		}
+	}
+	if got, _ := gotClient.Load().(string); got != "nas-1" {
+		t.Errorf("Client = %q, want %q", got, "nas-1")
+	}
+}
+
+func TestRadSecClientTable(t *testing.T) {
+	pki := NewTestPKI(t)
+	var gotClient atomic.Value
+	handler := func(packet *JsonPacket) error {
+		gotClient.Store(packet.Client)
+		return nil
+	}
+	clients := NewClientTable()
+	clients.Add(Client{CertificateName: "nas-2", Name: "site-b"})
+	addr := StartRadSecServer(t, pki, handler, clients)
+	for _, name := range []string{"nas-2", "nas-3"} {
+		conn, err := tls.Dial("tcp", addr, &tls.Config{
+			RootCAs:      pki.pool,
+			ServerName:   "localhost",
+			Certificates: []tls.Certificate{pki.Certificate(t, name, x509.ExtKeyUsageClientAuth)},
+		})
+		if err != nil {
+			t.Fatalf("dial failed with: %v", err)
+		}
+		testPacket := GetTestPacket(1)
+		SignTestPacket(testPacket, RadSecSecret)
+		_, err = ExchangeStreamPacket(conn, testPacket)
+		conn.Close()
+		if name == "nas-2" && err != nil {
+			t.Errorf("exchange for known client failed with: %v", err)
+		}
+		if name == "nas-3" && err == nil {
+			t.Errorf("exchange for unknown client succeeded")
+		}
+	}
+	if got, _ := gotClient.Load().(string); got != "site-b" {
+		t.Errorf("Client = %q, want %q", got, "site-b")
+	}
+}
+
+func TestRadSecWithoutClientCertificate(t *testing.T) {
+	pki := NewTestPKI(t)
+	handler := func(packet *JsonPacket) error {
+		return nil
+	}
+	addr := StartRadSecServer(t, pki, handler, nil)
+	conn, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: pki.pool, ServerName: "localhost"})
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	testPacket := GetTestPacket(1)
+	SignTestPacket(testPacket, RadSecSecret)
+	if _, err := ExchangeStreamPacket(conn, testPacket); err == nil {
+		t.Errorf("exchange without client certificate succeeded")
+	}
+}
+
+// NewTCPTestServer returns a TCP server with the secret "secret"
+func NewTCPTestServer(f func(packet *JsonPacket) error, idleTimeout int) *PacketServer {
+	return &PacketServer{
+		Network:            NETWORK_TYPE_TCP,
+		Secret:             "secret",
+		IdleTimeoutSeconds: idleTimeout,
+		HandleRequest:      f,
+	}
+}
+
+func TestTCPRequest(t *testing.T) {
+	var count atomic.Int32
+	handler := func(packet *JsonPacket) error {
+		count.Add(1)
+		return nil
+	}
+	addr := StartTestServer(t, NewTCPTestServer(handler, 0))
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial failed with: %v", err)
+	}
+	defer conn.Close()
+	// the same packet twice is no duplicate on a single connection
+	for i := 0; i < 2; i++ {
+		res, err := ExchangeStreamPacket(conn, GetTestPacket(1))
+		if err != nil {
+			t.Fatalf("exchange failed with: %v", err)
+		}
+		if Code(res[0]) != CodeAccountingResponse {
+			t.Errorf("response.Code = %q, want %q", Code(res[0]), CodeAccountingResponse)
+		}
+	}
+	if got := count.Load(); got != 2 {
+		t.Errorf("handled packets = %d, want %d", got, 2)
+	}
+}
+
+func TestTCPIdleTimeout(t *testing.T) {
+	handler := func(packet *JsonPacket) error {
+		return nil
+	}
+	addr := StartTestServer(t, NewTCPTestServer(handler, 1))
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial failed with: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("read on idle connection = %v, want %v", err, io.EOF)
+	}
+}
+
+func TestReadStreamPacket(t *testing.T) {
+	first, second := GetTestPacket(1), GetTestPacket(2)
+	r := bytes.NewReader(append(append([]byte(nil), first...), second...))
+	for _, want := range [][]byte{first, second} {
+		got, err := readStreamPacket(r)
+		if err != nil {
+			t.Fatalf("readStreamPacket failed with: %v", err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("got %x, want %x", got, want)
+		}
+	}
+	if _, err := readStreamPacket(bytes.NewReader([]byte{4, 1, 0, 2})); err == nil {
+		t.Errorf("invalid length accepted")
+	}
+}
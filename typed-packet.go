@@ -0,0 +1,95 @@
+package accter
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+/*
+ * TypedPacket is the second generation packet model. Attribute values keep
+ * their type, see TypedAttribute.
+ */
+type TypedPacket struct {
+	Id            uint8            `json:"id"`
+	Authenticator string           `json:"authenticator"`
+	Code          string           `json:"code"`
+	Key           string           `json:"key"`
+	RemoteAddr    string           `json:"remote_addr"`
+	Client        string           `json:"client,omitempty"`
+	Attributes    []TypedAttribute `json:"attributes"`
+}
+
+/*
+ * TypedAttribute holds the value as uint32, uint64, net.IP, netip.Prefix,
+ * time.Time, []byte or string depending on the data type of the attribute.
+ * Values which can not be decoded are nil and the reason is set in Error.
+ */
+type TypedAttribute struct {
+	Name  string      `json:"name"`
+	Value interface{} `json:"value"`
+	// Enum is the name of enumerated values
+	Enum string `json:"enum,omitempty"`
+	// Tag groups the attributes of a tunnel, see JsonAttribute.Tag
+	Tag   uint8  `json:"tag,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// NewTypedPacket converts a parsed packet into the typed packet model
+func NewTypedPacket(p *RequestPacket) *TypedPacket {
+	return newTypedPacket(p, resolveAttributes(p.Attributes))
+}
+
+func newTypedPacket(p *RequestPacket, resolved []resolvedAttribute) *TypedPacket {
+	packet := &TypedPacket{
+		Id:            p.Identifier,
+		Authenticator: hex.EncodeToString(p.Authenticator[:]),
+		Code:          p.Code.String(),
+		Attributes:    make([]TypedAttribute, 0, len(p.Attributes)),
+	}
+	packet.Key = fmt.Sprintf("%#x", p.Identifier) + "_" + packet.Authenticator
+	for _, r := range resolved {
+		packet.Attributes = append(packet.Attributes, r.toTypedAttribute())
+	}
+	return packet
+}
+
+// Typed returns the typed packet model of a received packet, decrypted values included
+func (p *JsonPacket) Typed() *TypedPacket {
+	if p.request == nil {
+		return nil
+	}
+	packet := newTypedPacket(p.request, p.resolved)
+	packet.RemoteAddr = p.RemoteAddr
+	packet.Client = p.Client
+	return packet
+}
+
+func (r resolvedAttribute) toTypedAttribute() TypedAttribute {
+	attr := r.helper.toTypedAttribute(r.value)
+	attr.Tag = r.tag
+	return attr
+}
+
+func (a AttributeHelper) toTypedAttribute(b []byte) TypedAttribute {
+	attr := TypedAttribute{Name: a.Name}
+	value, err := a.Type.decode(b)
+	if err != nil {
+		attr.Error = err.Error()
+		return attr
+	}
+	attr.Value = value
+	if name, _, ok := a.Enumeration(b); ok {
+		attr.Enum = name
+	}
+	return attr
+}
+
+// MarshalJSON writes octets as hex string like the JsonPacket does
+func (a TypedAttribute) MarshalJSON() ([]byte, error) {
+	type attribute TypedAttribute
+	if b, ok := a.Value.([]byte); ok {
+		a.Value = hex.EncodeToString(b)
+	}
+	return json.Marshal(attribute(a))
+}
@@ -0,0 +1,93 @@
+package accter
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTypedPacket(t *testing.T) {
+	b := AppendTestAttribute(GetTestPacket(1), 97, []byte{0, 56, 0x20, 0x01, 0x0d, 0xb8, 0x12, 0x34, 0x56})
+	b = AppendTestAttribute(b, 4, []byte{1, 2, 3})
+	b = AppendTestAttribute(b, 64, []byte{1, 0, 0, 3})
+	packet, err := ParsePacket(b, []byte("secret"))
+	if err != nil {
+		t.Fatalf("ParsePacket failed with: %v", err)
+	}
+	typed := NewTypedPacket(packet)
+	values := make(map[string]TypedAttribute)
+	for _, attr := range typed.Attributes {
+		values[attr.Name] = attr
+	}
+	if got, ok := values["Acct-Input-Octets"].Value.(uint32); !ok || got != 1234 {
+		t.Errorf("Acct-Input-Octets = %v, want %d", values["Acct-Input-Octets"].Value, 1234)
+	}
+	if got := values["Acct-Status-Type"]; got.Value != uint32(3) || got.Enum != "Interim-Update" {
+		t.Errorf("Acct-Status-Type = %v, want 3 (Interim-Update)", got)
+	}
+	if got := values["Tunnel-Type"]; got.Value != uint32(3) || got.Enum != "L2TP" || got.Tag != 1 {
+		t.Errorf("Tunnel-Type = %v, want 3 (L2TP) with tag 1", got)
+	}
+	if got, ok := values["Acct-Session-Id"].Value.(string); !ok || got != "t-800" {
+		t.Errorf("Acct-Session-Id = %v, want %s", values["Acct-Session-Id"].Value, "t-800")
+	}
+	if got, ok := values["Framed-IPv6-Prefix"].Value.(netip.Prefix); !ok || got.String() != "2001:db8:1234:5600::/56" {
+		t.Errorf("Framed-IPv6-Prefix = %v, want %s", values["Framed-IPv6-Prefix"].Value, "2001:db8:1234:5600::/56")
+	}
+	var ips []TypedAttribute
+	for _, attr := range typed.Attributes {
+		if attr.Name == "NAS-IP-Address" {
+			ips = append(ips, attr)
+		}
+	}
+	if len(ips) != 2 {
+		t.Fatalf("got %d NAS-IP-Address, want %d", len(ips), 2)
+	}
+	if got, ok := ips[0].Value.(net.IP); !ok || !got.Equal(net.IPv4(1, 2, 3, 4)) {
+		t.Errorf("NAS-IP-Address = %v, want %s", ips[0].Value, "1.2.3.4")
+	}
+	if ips[1].Value != nil || ips[1].Error == "" {
+		t.Errorf("NAS-IP-Address with wrong length = %v, want an error", ips[1])
+	}
+}
+
+func TestTypedAttributeJSON(t *testing.T) {
+	tests := []struct {
+		attr TypedAttribute
+		want string
+	}{
+		{TypedAttribute{Name: "Acct-Input-Octets", Value: uint32(1234)}, `{"name":"Acct-Input-Octets","value":1234}`},
+		{TypedAttribute{Name: "Class", Value: []byte{0xca, 0xfe}}, `{"name":"Class","value":"cafe"}`},
+		{TypedAttribute{Name: "NAS-IP-Address", Value: net.IPv4(1, 2, 3, 4)}, `{"name":"NAS-IP-Address","value":"1.2.3.4"}`},
+		{TypedAttribute{Name: "Event-Timestamp", Value: time.Unix(0, 0).UTC()}, `{"name":"Event-Timestamp","value":"1970-01-01T00:00:00Z"}`},
+		{TypedAttribute{Name: "NAS-Port", Error: "wrong length 3 for integer"}, `{"name":"NAS-Port","value":null,"error":"wrong length 3 for integer"}`},
+	}
+	for _, test := range tests {
+		got, err := json.Marshal(test.attr)
+		if err != nil {
+			t.Fatalf("json.Marshal failed with: %v", err)
+		}
+		if string(got) != test.want {
+			t.Errorf("got %s, want %s", got, test.want)
+		}
+	}
+}
+
+func TestTypedFromJsonPacket(t *testing.T) {
+	var typed *TypedPacket
+	handler := func(packet *JsonPacket) error {
+		typed = packet.Typed()
+		return nil
+	}
+	addr := StartTestServer(t, NewTestServer(handler, true))
+	if _, err := ExchangePacket(context.Background(), GetTestPacket(1), addr); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if typed == nil || typed.Id != 1 || !strings.HasPrefix(typed.RemoteAddr, "127.0.0.1") && !strings.HasPrefix(typed.RemoteAddr, "[::1]") {
+		t.Errorf("typed packet = %v, want id 1 from localhost", typed)
+	}
+}
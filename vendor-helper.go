@@ -0,0 +1,168 @@
+package accter
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+type VendorHelper struct {
+	Name string
+	// TypeSize is the size of the vendor type field in bytes (1, 2 or 4), defaults to 1
+	TypeSize int
+	// LengthSize is the size of the vendor length field in bytes (1 or 2), defaults to 1
+	LengthSize int
+	// NoLength is set for vendors without length field (format=4,0), the attribute fills the Vendor-Specific
+	NoLength bool
+	// Continuation is set for vendors with a continuation byte after the length like WiMAX (format=1,1,c)
+	Continuation bool
+	Attributes   map[int]AttributeHelper
+}
+
+type VendorAttribute struct {
+	Type  int
+	Value []byte
+}
+
+var Vendors = map[uint32]VendorHelper{
+	9: {Name: "Cisco", Attributes: map[int]AttributeHelper{
+		1:   {Name: "Cisco-AVPair", Type: TypeString},
+		2:   {Name: "Cisco-NAS-Port", Type: TypeString},
+		250: {Name: "Cisco-Account-Info", Type: TypeString},
+		251: {Name: "Cisco-Service-Info", Type: TypeString},
+		252: {Name: "Cisco-Command-Code", Type: TypeString},
+	}},
+	311: {Name: "Microsoft", Attributes: map[int]AttributeHelper{
+		1:  {Name: "MS-CHAP-Response", Type: TypeOctets},
+		2:  {Name: "MS-CHAP-Error", Type: TypeString},
+		7:  {Name: "MS-MPPE-Encryption-Policy", Type: TypeInteger},
+		8:  {Name: "MS-MPPE-Encryption-Types", Type: TypeInteger},
+		11: {Name: "MS-CHAP-Challenge", Type: TypeOctets},
+		12: {Name: "MS-CHAP-MPPE-Keys", Type: TypeOctets},
+		16: {Name: "MS-MPPE-Send-Key", Type: TypeOctets, Encrypted: true},
+		17: {Name: "MS-MPPE-Recv-Key", Type: TypeOctets, Encrypted: true},
+		25: {Name: "MS-CHAP2-Response", Type: TypeOctets},
+		26: {Name: "MS-CHAP2-Success", Type: TypeOctets},
+		28: {Name: "MS-Primary-DNS-Server", Type: TypeIPAddr},
+		29: {Name: "MS-Secondary-DNS-Server", Type: TypeIPAddr},
+	}},
+	2636: {Name: "Juniper", Attributes: map[int]AttributeHelper{
+		1: {Name: "Juniper-Local-User-Name", Type: TypeString},
+		2: {Name: "Juniper-Allow-Commands", Type: TypeString},
+		3: {Name: "Juniper-Deny-Commands", Type: TypeString},
+		4: {Name: "Juniper-Allow-Configuration", Type: TypeString},
+		5: {Name: "Juniper-Deny-Configuration", Type: TypeString},
+	}},
+	8164: {Name: "Starent", TypeSize: 2, LengthSize: 2, Attributes: map[int]AttributeHelper{
+		1: {Name: "SN-VPN-ID", Type: TypeInteger},
+		2: {Name: "SN-VPN-Name", Type: TypeString},
+	}},
+	10415: {Name: "3GPP", Attributes: map[int]AttributeHelper{
+		1:  {Name: "3GPP-IMSI", Type: TypeString},
+		2:  {Name: "3GPP-Charging-ID", Type: TypeInteger},
+		3:  {Name: "3GPP-PDP-Type", Type: TypeInteger},
+		4:  {Name: "3GPP-Charging-Gateway-Address", Type: TypeIPAddr},
+		5:  {Name: "3GPP-GPRS-Negotiated-QoS-profile", Type: TypeString},
+		6:  {Name: "3GPP-SGSN-Address", Type: TypeIPAddr},
+		7:  {Name: "3GPP-GGSN-Address", Type: TypeIPAddr},
+		8:  {Name: "3GPP-IMSI-MCC-MNC", Type: TypeString},
+		9:  {Name: "3GPP-GGSN-MCC-MNC", Type: TypeString},
+		10: {Name: "3GPP-NSAPI", Type: TypeString},
+		12: {Name: "3GPP-Selection-Mode", Type: TypeString},
+		13: {Name: "3GPP-Charging-Characteristics", Type: TypeString},
+		18: {Name: "3GPP-SGSN-MCC-MNC", Type: TypeString},
+		20: {Name: "3GPP-IMEISV", Type: TypeString},
+		21: {Name: "3GPP-RAT-Type", Type: TypeOctets},
+		22: {Name: "3GPP-User-Location-Info", Type: TypeOctets},
+		23: {Name: "3GPP-MS-TimeZone", Type: TypeOctets},
+	}},
+}
+
+/*
+ * ParseVendorSpecific splits the value of a Vendor-Specific attribute as
+ * described in RFC 2865 section 5.26. While:
+ *  - b[0:4] is the vendor id
+ *  - b[4:rest] are the vendor attributes with the type and length sizes of the vendor
+ */
+func ParseVendorSpecific(b []byte) (uint32, []VendorAttribute, error) {
+	if len(b) < 5 {
+		return 0, nil, errors.New("invalid vendor specific attribute: short buffer")
+	}
+	vendorId := binary.BigEndian.Uint32(b[0:4])
+	attrs, err := parseVendorAttributes(b[4:], Vendors[vendorId])
+	return vendorId, attrs, err
+}
+
+/*
+ * format returns the sizes of the type and length fields and of the whole
+ * header of the vendor attributes. The continuation byte is part of the
+ * header, values continued in the next Vendor-Specific are not joined.
+ */
+func (v VendorHelper) format() (int, int, int) {
+	typeSize, lengthSize := 1, 1
+	if v.TypeSize != 0 {
+		typeSize = v.TypeSize
+	}
+	if v.LengthSize != 0 {
+		lengthSize = v.LengthSize
+	}
+	if v.NoLength {
+		lengthSize = 0
+	}
+	header := typeSize + lengthSize
+	if v.Continuation {
+		header++
+	}
+	return typeSize, lengthSize, header
+}
+
+func parseVendorAttributes(b []byte, vendor VendorHelper) ([]VendorAttribute, error) {
+	typeSize, lengthSize, header := vendor.format()
+	attrs := make([]VendorAttribute, 0)
+	for len(b) > 0 {
+		if len(b) < header {
+			return nil, errors.New("invalid vendor attribute: short buffer")
+		}
+		attr := VendorAttribute{Type: int(readVendorField(b[:typeSize]))}
+		length := len(b)
+		if lengthSize > 0 {
+			length = int(readVendorField(b[typeSize : typeSize+lengthSize]))
+		}
+		if length < header || length > len(b) {
+			return nil, errors.New("invalid vendor attribute length")
+		}
+		attr.Value = b[header:length]
+		attrs = append(attrs, attr)
+		b = b[length:]
+	}
+	return attrs, nil
+}
+
+func readVendorField(b []byte) uint32 {
+	switch len(b) {
+	case 1:
+		return uint32(b[0])
+	case 2:
+		return uint32(binary.BigEndian.Uint16(b))
+	case 4:
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+// resolveVendorSpecific resolves the vendor attributes of a Vendor-Specific attribute
+func resolveVendorSpecific(b []byte) ([]resolvedAttribute, error) {
+	vendorId, attrs, err := ParseVendorSpecific(b)
+	if err != nil {
+		return nil, err
+	}
+	resolved := make([]resolvedAttribute, 0, len(attrs))
+	for _, v := range attrs {
+		attr, ok := Vendors[vendorId].Attributes[v.Type]
+		if !ok {
+			attr = AttributeHelper{Name: fmt.Sprintf("Vendor-%d-Attr-%d", vendorId, v.Type), Type: TypeOctets}
+		}
+		resolved = append(resolved, attr.resolve(v.Value))
+	}
+	return resolved, nil
+}
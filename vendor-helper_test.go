@@ -0,0 +1,49 @@
+package accter
+
+import "testing"
+
+func TestCiscoAVPair(t *testing.T) {
+	value := append([]byte{0, 0, 0, 9, 1, 2 + 12}, []byte("ip:addr=pool")...)
+	got := toJsonAttributes(RadiusAttribute{Type: 26, Value: value})
+	if len(got) != 1 || got[0].Name != "Cisco-AVPair" || got[0].Value != "ip:addr=pool" {
+		t.Errorf("got %v, want [{Cisco-AVPair ip:addr=pool}]", got)
+	}
+}
+
+func TestMultipleVendorAttributes(t *testing.T) {
+	value := []byte{0, 0, 0x28, 0xaf, 2, 6, 0, 0, 0, 7, 9, 7, '2', '2', '8', '0', '1'}
+	got := toJsonAttributes(RadiusAttribute{Type: 26, Value: value})
+	want := []JsonAttribute{{Name: "3GPP-Charging-ID", Value: "7"}, {Name: "3GPP-GGSN-MCC-MNC", Value: "22801"}}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("got %v, want %v", got[i], want[i])
+		}
+	}
+}
+
+func TestNonStandardVendorFormat(t *testing.T) {
+	value := append([]byte{0, 0, 0x1f, 0xe4, 0, 2, 0, 4 + 8}, []byte("internet")...)
+	got := toJsonAttributes(RadiusAttribute{Type: 26, Value: value})
+	if len(got) != 1 || got[0].Name != "SN-VPN-Name" || got[0].Value != "internet" {
+		t.Errorf("got %v, want [{SN-VPN-Name internet}]", got)
+	}
+}
+
+func TestUnknownVendor(t *testing.T) {
+	value := []byte{0, 0, 0x30, 0x39, 7, 4, 0xca, 0xfe}
+	got := toJsonAttributes(RadiusAttribute{Type: 26, Value: value})
+	if len(got) != 1 || got[0].Name != "Vendor-12345-Attr-7" || got[0].Value != "cafe" {
+		t.Errorf("got %v, want [{Vendor-12345-Attr-7 cafe}]", got)
+	}
+}
+
+func TestInvalidVendorSpecific(t *testing.T) {
+	value := []byte{0, 0, 0, 9, 1, 20, 'x'}
+	got := toJsonAttributes(RadiusAttribute{Type: 26, Value: value})
+	if len(got) != 1 || got[0].Name != "Vendor-Specific" || got[0].Value != "000000090114"+"78" {
+		t.Errorf("got %v, want [{Vendor-Specific 00000009011478}]", got)
+	}
+}